@@ -0,0 +1,470 @@
+package srv
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IsArchive reports whether name looks like an archive NewArchiveFS can
+// open, judging by its extension.
+func IsArchive(name string) bool {
+	return archiveKind(name) != ""
+}
+
+func archiveKind(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// OpenArchive opens the local zip, tar or tar.gz file at name as a read-only
+// filesystem. The file stays open for the lifetime of the returned FS.
+func OpenArchive(name string) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fsys, err := NewArchiveFS(name, f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// NewArchiveFS returns a read-only filesystem over the archive in r, whose
+// format is chosen by the extension of name. Files stored uncompressed (zip
+// entries using the Store method, and everything in a plain tar) can be
+// read at random, so range requests work on them.
+func NewArchiveFS(name string, r io.ReaderAt, size int64) (fs.FS, error) {
+	switch archiveKind(name) {
+	case "zip":
+		return newZipFS(r, size)
+	case "tar":
+		return newTarFS(r, size, false)
+	case "tar.gz":
+		return newTarFS(r, size, true)
+	}
+	return nil, errors.New("unsupported archive format: " + name)
+}
+
+// sectionFile is an archive member readable at random.
+type sectionFile struct {
+	*io.SectionReader
+	fi fs.FileInfo
+}
+
+func (f *sectionFile) Stat() (fs.FileInfo, error) { return f.fi, nil }
+func (f *sectionFile) Close() error               { return nil }
+
+// zipFS is a zip.Reader whose stored entries can be seeked.
+type zipFS struct {
+	*zip.Reader
+	r     io.ReaderAt
+	files map[string]*zip.File
+}
+
+func newZipFS(r io.ReaderAt, size int64) (*zipFS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	z := &zipFS{Reader: zr, r: r, files: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		z.files[strings.TrimPrefix(path.Clean("/"+f.Name), "/")] = f
+	}
+	return z, nil
+}
+
+func (z *zipFS) Open(name string) (fs.File, error) {
+	if f, ok := z.files[name]; ok && f.Method == zip.Store && f.Mode().IsRegular() {
+		off, err := f.DataOffset()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &sectionFile{io.NewSectionReader(z.r, off, int64(f.UncompressedSize64)), f.FileInfo()}, nil
+	}
+	return z.Reader.Open(name)
+}
+
+// tarFS is an index of a tar archive. Members of a plain tar are read in
+// place; members of a compressed one are found by decompressing the archive
+// again up to them.
+type tarFS struct {
+	r        io.ReaderAt
+	size     int64
+	gzipped  bool
+	entries  map[string]*tarEntry
+	children map[string][]string
+}
+
+type tarEntry struct {
+	hdr    *tar.Header
+	index  int   // position in the archive
+	offset int64 // data offset in a plain tar, or -1
+}
+
+// countingReader tracks the offset tar.Reader has reached, and lets it skip
+// member data by seeking.
+type countingReader struct {
+	*io.SectionReader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.SectionReader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := c.SectionReader.Seek(offset, whence)
+	c.n = n
+	return n, err
+}
+
+func newTarFS(r io.ReaderAt, size int64, gzipped bool) (*tarFS, error) {
+	t := &tarFS{
+		r:        r,
+		size:     size,
+		gzipped:  gzipped,
+		entries:  make(map[string]*tarEntry),
+		children: make(map[string][]string),
+	}
+	cr := &countingReader{SectionReader: io.NewSectionReader(r, 0, size)}
+	tr, closer, err := t.reader(cr)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var links []*tarEntry
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		e := &tarEntry{hdr: hdr, index: i, offset: -1}
+		if !gzipped && !isSparse(hdr) {
+			e.offset = cr.n
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			links = append(links, e)
+		}
+		t.add(name, e)
+	}
+	// Hard links share the data of their target.
+	for _, e := range links {
+		target, ok := t.entries[strings.TrimPrefix(path.Clean("/"+e.hdr.Linkname), "/")]
+		if !ok || target.hdr == nil {
+			continue
+		}
+		hdr := *target.hdr
+		hdr.Name = e.hdr.Name
+		e.hdr, e.index, e.offset = &hdr, target.index, target.offset
+	}
+	for _, names := range t.children {
+		sort.Strings(names)
+	}
+	return t, nil
+}
+
+// add records an entry and any parent directories the archive leaves
+// implicit.
+func (t *tarFS) add(name string, e *tarEntry) {
+	if _, ok := t.entries[name]; ok {
+		// A later member replaces an earlier one of the same name.
+		if e.hdr != nil {
+			t.entries[name] = e
+		}
+		return
+	}
+	t.entries[name] = e
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = "."
+	} else {
+		t.add(dir, &tarEntry{index: -1, offset: -1})
+	}
+	t.children[dir] = append(t.children[dir], base)
+}
+
+// isSparse reports whether a member's data is stored in sparse form, and so
+// can't be read in place.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tarFS) reader(r io.Reader) (*tar.Reader, io.Closer, error) {
+	if !t.gzipped {
+		return tar.NewReader(r), io.NopCloser(nil), nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tar.NewReader(zr), zr, nil
+}
+
+func (t *tarFS) stat(op, name string) (*tarEntry, fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, implicitDir("."), nil
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if e.hdr == nil {
+		return e, implicitDir(path.Base(name)), nil
+	}
+	return e, e.hdr.FileInfo(), nil
+}
+
+func (t *tarFS) Stat(name string) (fs.FileInfo, error) {
+	_, fi, err := t.stat("stat", name)
+	return fi, err
+}
+
+func (t *tarFS) Lstat(name string) (fs.FileInfo, error) {
+	return t.Stat(name)
+}
+
+func (t *tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	_, fi, err := t.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	var entries []fs.DirEntry
+	for _, base := range t.children[name] {
+		_, fi, err := t.stat("readdir", path.Join(name, base))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fi))
+	}
+	return entries, nil
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	e, fi, err := t.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		entries, err := t.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirFile{fi: fi, entries: entries}, nil
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if e.offset >= 0 {
+		return &sectionFile{io.NewSectionReader(t.r, e.offset, fi.Size()), fi}, nil
+	}
+
+	tr, closer, err := t.reader(io.NewSectionReader(t.r, 0, t.size))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i <= e.index; i++ {
+		if _, err := tr.Next(); err != nil {
+			closer.Close()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	return &streamFile{Reader: tr, Closer: closer, fi: fi}, nil
+}
+
+// streamFile is an archive member that can only be read in order.
+type streamFile struct {
+	io.Reader
+	io.Closer
+	fi fs.FileInfo
+}
+
+func (f *streamFile) Stat() (fs.FileInfo, error) { return f.fi, nil }
+
+// dirFile is an open directory with a precomputed list of entries.
+type dirFile struct {
+	fi      fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.fi, nil }
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.fi.Name(), Err: errors.New("is a directory")}
+}
+func (d *dirFile) Close() error { return nil }
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// implicitDir describes a directory that exists only as the parent of
+// archive members.
+type implicitDir string
+
+func (d implicitDir) Name() string       { return string(d) }
+func (d implicitDir) Size() int64        { return 0 }
+func (d implicitDir) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d implicitDir) ModTime() time.Time { return time.Time{} }
+func (d implicitDir) IsDir() bool        { return true }
+func (d implicitDir) Sys() interface{}   { return nil }
+
+// maxBufferedArchive caps the size of a nested archive that has to be read
+// into memory because its container can't be read at random.
+const maxBufferedArchive = 64 << 20
+
+// maxCachedArchives bounds the number of archives kept open for browsing.
+const maxCachedArchives = 64
+
+// archiveCache holds the archives opened while browsing into them, keyed by
+// their path from the served root.
+type archiveCache struct {
+	mu       sync.Mutex
+	archives map[string]*cachedArchive
+}
+
+type cachedArchive struct {
+	fsys    fs.FS
+	size    int64
+	modTime time.Time
+}
+
+// resolve maps name onto the filesystem it lives in, descending into any
+// archives along the way. The last element is only entered if dirSlash is
+// set, i.e. the request asked for it as a directory.
+func (c *archiveCache) resolve(fsys fs.FS, name string, dirSlash bool) (fs.FS, string, error) {
+	if name == "." {
+		return fsys, name, nil
+	}
+	parts := strings.Split(name, "/")
+	start := 0
+	for i := range parts {
+		if i == len(parts)-1 && !dirSlash {
+			break
+		}
+		if !IsArchive(parts[i]) {
+			continue
+		}
+		inner := path.Join(parts[start : i+1]...)
+		fi, err := lstat(fsys, inner)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		archive, err := c.open(fsys, path.Join(parts[:i+1]...), inner, fi)
+		if err != nil {
+			return nil, "", err
+		}
+		fsys, start = archive, i+1
+	}
+	if start == len(parts) {
+		return fsys, ".", nil
+	}
+	return fsys, path.Join(parts[start:]...), nil
+}
+
+func (c *archiveCache) open(fsys fs.FS, key, name string, fi fs.FileInfo) (fs.FS, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if a, ok := c.archives[key]; ok && a.size == fi.Size() && a.modTime.Equal(fi.ModTime()) {
+		return a.fsys, nil
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		defer f.Close()
+		if fi.Size() > maxBufferedArchive {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("compressed archive too large to browse")}
+		}
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	// Archives dropped from the cache are left to the finalizers of their
+	// files, as requests may still be reading from them.
+	archive, err := NewArchiveFS(name, r, fi.Size())
+	if err != nil {
+		if ok {
+			f.Close()
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if c.archives == nil {
+		c.archives = make(map[string]*cachedArchive)
+	}
+	if len(c.archives) >= maxCachedArchives {
+		for k := range c.archives {
+			delete(c.archives, k)
+			break
+		}
+	}
+	c.archives[key] = &cachedArchive{fsys: archive, size: fi.Size(), modTime: fi.ModTime()}
+	return archive, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"srv"
+)
+
+// spaFlag collects -spa flags of the form [prefix=]file.
+type spaFlag map[string]string
+
+func (f spaFlag) String() string {
+	var specs []string
+	for prefix, file := range f {
+		specs = append(specs, prefix+"="+file)
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f spaFlag) Set(s string) error {
+	prefix, file := "/", s
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		prefix, file = s[:i], s[i+1:]
+	}
+	if !strings.HasPrefix(prefix, "/") || file == "" {
+		return errors.New("expected [/prefix=]file")
+	}
+	f[prefix] = file
+	return nil
+}
+
+// errorPagesFlag collects -error-page flags of the form status=file.
+type errorPagesFlag map[int]string
+
+func (f errorPagesFlag) String() string {
+	var specs []string
+	for code, file := range f {
+		specs = append(specs, fmt.Sprintf("%d=%s", code, file))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f errorPagesFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return errors.New("expected status=file")
+	}
+	code, err := strconv.Atoi(s[:i])
+	if err != nil || code < 400 || code > 599 || s[i+1:] == "" {
+		return errors.New("expected status=file, with a 4xx or 5xx status")
+	}
+	f[code] = s[i+1:]
+	return nil
+}
+
+// rulesFlag collects -redirect flags, each a rule in _redirects syntax.
+type rulesFlag []srv.Rule
+
+func (f *rulesFlag) String() string {
+	var specs []string
+	for _, r := range *f {
+		specs = append(specs, fmt.Sprintf("%s %s %d", r.From, r.To, r.Status))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *rulesFlag) Set(s string) error {
+	rules, err := srv.ParseRules(strings.NewReader(s))
+	if err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "line 1: "))
+	}
+	if len(rules) != 1 {
+		return errors.New("expected from to [status][!]")
+	}
+	*f = append(*f, rules[0])
+	return nil
+}
+
+// headersFlag collects -header flags of the form [pattern ]Name: value.
+type headersFlag []srv.HeaderRule
+
+func (f *headersFlag) String() string {
+	var specs []string
+	for _, r := range *f {
+		for name, values := range r.Header {
+			specs = append(specs, fmt.Sprintf("%s %s: %s", r.Path, name, strings.Join(values, ", ")))
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *headersFlag) Set(s string) error {
+	pattern, header := "/*", s
+	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, "^") {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			return errors.New("expected [pattern ]Name: value")
+		}
+		pattern, header = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	rules, err := srv.ParseHeaders(strings.NewReader(pattern + "\n  " + header))
+	if err != nil {
+		return errors.New(strings.TrimPrefix(strings.TrimPrefix(err.Error(), "line 1: "), "line 2: "))
+	}
+	*f = append(*f, rules[0])
+	return nil
+}
+
+// listFlag is a comma-separated list. Setting it replaces its default.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(s string) error {
+	*f = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
+	return nil
+}
+
+// proxyFlag collects -proxy flags of the form /prefix=url.
+type proxyFlag []srv.Proxy
+
+func (f *proxyFlag) String() string {
+	var specs []string
+	for _, p := range *f {
+		specs = append(specs, p.Prefix+"="+p.Target.String())
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f *proxyFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 0 || !strings.HasPrefix(s, "/") {
+		return errors.New("expected /prefix=url")
+	}
+	target, err := url.Parse(s[i+1:])
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("expected /prefix=url, with an http or https URL")
+	}
+	*f = append(*f, srv.Proxy{Prefix: s[:i], Target: target})
+	return nil
+}
+
+// headerFlag collects flags of the form Name: value.
+type headerFlag http.Header
+
+func (f headerFlag) String() string {
+	var specs []string
+	for name, values := range f {
+		specs = append(specs, name+": "+strings.Join(values, ", "))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (f headerFlag) Set(s string) error {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 {
+		return errors.New("expected Name: value")
+	}
+	http.Header(f).Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// listen listens on host:port or, while that's taken, on up to retries
+// ports after it, and then on any free port if anyPort is set.
+func listen(host, port string, retries int, anyPort bool) (net.Listener, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	n, perr := strconv.Atoi(port)
+	for i := 1; i <= retries && perr == nil && errors.Is(err, syscall.EADDRINUSE) && n+i <= 65535; i++ {
+		ln, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(n+i)))
+	}
+	if anyPort && errors.Is(err, syscall.EADDRINUSE) {
+		ln, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+	}
+	return ln, err
+}
+
+// listenInfo describes where srv listens, for scripts.
+type listenInfo struct {
+	Addr string   `json:"addr"`
+	Port int      `json:"port"`
+	URLs []string `json:"urls"`
+}
+
+// reportAddr writes where srv listens as a line of JSON to stdout, and to
+// the file addrFile, as asked. The file appears complete or not at all.
+func reportAddr(info listenInfo, stdout bool, addrFile string) error {
+	line, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if stdout {
+		if _, err := os.Stdout.Write(line); err != nil {
+			return err
+		}
+	}
+	if addrFile == "" {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(addrFile), ".srv-addr-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), addrFile)
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"srv"
+)
+
+func checkDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		die(err.Error())
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		die("%s isn't a directory.", dir)
+	}
+}
+
+// openLayer opens a directory, an archive file, or an s3://bucket/prefix
+// URL to serve.
+func openLayer(name string) fs.FS {
+	if strings.HasPrefix(name, "s3://") {
+		bucket, prefix := name[len("s3://"):], ""
+		if i := strings.IndexByte(bucket, '/'); i >= 0 {
+			bucket, prefix = bucket[:i], bucket[i+1:]
+		}
+		s3opts.Bucket, s3opts.Prefix = bucket, prefix
+		return srv.NewS3FS(s3opts)
+	}
+	if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() && srv.IsArchive(name) {
+		fsys, err := srv.OpenArchive(name)
+		if err != nil {
+			die("Could not open archive %s: %s", name, err)
+		}
+		return fsys
+	}
+	checkDir(name)
+	return srv.DirFS(name)
+}
+
+// splitRoots splits a root spec into its layers, keeping s3:// URLs whole.
+func splitRoots(spec string) []string {
+	var roots []string
+	for _, p := range filepath.SplitList(spec) {
+		if n := len(roots); n > 0 && roots[n-1] == "s3" && strings.HasPrefix(p, "//") {
+			roots[n-1] += ":" + p
+			continue
+		}
+		roots = append(roots, p)
+	}
+	return roots
+}
+
+// openRoot opens a served root: one directory or archive, or several
+// separated by the OS path list separator to be overlaid.
+func openRoot(spec string) fs.FS {
+	var layers srv.Overlay
+	for _, name := range splitRoots(spec) {
+		layers = append(layers, openLayer(name))
+	}
+	if len(layers) == 1 {
+		return layers[0]
+	}
+	return layers
+}
+
+// localPaths lists the local files and directories srv serves.
+func localPaths(srvDir, onceFile, stdinName string, vhosts vhostList) []string {
+	switch {
+	case onceFile != "":
+		return []string{onceFile}
+	case stdinName != "":
+		return nil
+	}
+	specs := []string{srvDir}
+	for _, v := range vhosts {
+		specs = append(specs, v.dir)
+	}
+	var paths []string
+	for _, spec := range specs {
+		for _, name := range splitRoots(spec) {
+			if !strings.HasPrefix(name, "s3://") {
+				paths = append(paths, name)
+			}
+		}
+	}
+	return paths
+}
+
+// servesS3 reports whether any of the roots served is in S3.
+func servesS3(srvDir string, vhosts vhostList) bool {
+	specs := []string{srvDir}
+	for _, v := range vhosts {
+		specs = append(specs, v.dir)
+	}
+	for _, spec := range specs {
+		for _, name := range splitRoots(spec) {
+			if strings.HasPrefix(name, "s3://") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// corsPolicy returns the CORS policy for comma-separated origins, or "off",
+// with the settings of base.
+func corsPolicy(origins string, base srv.CORS) (*srv.CORS, error) {
+	if origins == "off" || origins == "" {
+		return nil, nil
+	}
+	(*listFlag)(&base.Origins).Set(origins)
+	if base.Credentials && containsString(base.Origins, "*") {
+		return nil, errors.New("-cors-credentials needs the origins to allow listed with -cors")
+	}
+	return &base, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// s3opts holds the S3 settings shared by all s3:// roots.
+var s3opts = srv.S3Options{
+	AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+	SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+}
+
+func loadCert(certFile, keyFile string) *tls.Certificate {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		die("Could not load certificate %s: %s", certFile, err)
+	}
+	return &cert
+}
+
+func die(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, v...)
+	os.Stderr.Write([]byte("\n"))
+	os.Exit(1)
+}
+
+var VERSION = "unknown"
+
+func main() {
+	var (
+		port, bindAddr, certFile, keyFile string
+		s3API, gitRev, notFoundPage       string
+		redirectsFile, headersFile        string
+		stdinName, onceFile               string
+		maxDownloads                      int
+		expire                            time.Duration
+		corsOrigins                       string
+		cors                              srv.CORS
+		proxies                           proxyFlag
+		proxyHeader                       = headerFlag{}
+		proxyResponseHeader               = headerFlag{}
+		proxyTimeout                      time.Duration
+		quiet, browseArchives, write      bool
+		gitHTTP, cleanURLs, stripHTML     bool
+		liveReload, liveReloadCSS, watch  bool
+		follow, fifos                     bool
+		showQR, openURL, mdns             bool
+		mdnsName, addrFile                string
+		portRetries                       int
+		anyPort, printAddr                bool
+		chroot, seccomp                   bool
+		sandboxOpts                       sandboxOptions
+		vhosts                            vhostList
+		rules                             rulesFlag
+		headers                           headersFlag
+		spa                               = spaFlag{}
+		errorPages                        = errorPagesFlag{}
+	)
+
+	flag.BoolVar(&quiet, "q", false, "quiet; disable all logging")
+	flag.StringVar(&port, "port", "8000", "port to listen on")
+	flag.IntVar(&portRetries, "port-retries", 0, "if the port is taken, try up to `n` ports after it")
+	flag.BoolVar(&anyPort, "any-port", false, "if the port (and any retries) are taken, listen on any free port")
+	flag.BoolVar(&printAddr, "print-addr", false, "print where the server listens to stdout, as a line of JSON")
+	flag.StringVar(&addrFile, "addr-file", "", "write where the server listens to `file`, as a line of JSON")
+	flag.StringVar(&sandboxOpts.user, "user", "", "once listening, switch to `user`, by name or id")
+	flag.StringVar(&sandboxOpts.group, "group", "", "once listening, switch to `group`, by name or id (default the user's)")
+	flag.BoolVar(&chroot, "chroot", false, "once listening, chroot into the served directory")
+	flag.StringVar(&sandboxOpts.landlock, "landlock", "", "once listening, use Landlock to allow only reading (`ro`) or also writing (rw) the served files")
+	flag.BoolVar(&seccomp, "seccomp", false, "once listening, block system calls srv doesn't need, such as execve")
+	flag.BoolVar(&showQR, "qr", false, "show a QR code of the server's URL on start")
+	flag.BoolVar(&openURL, "open", false, "open the server's URL in a browser on start")
+	flag.BoolVar(&mdns, "mdns", false, "advertise the server on the LAN over mDNS/DNS-SD")
+	flag.StringVar(&mdnsName, "mdns-name", "", "advertise the server as `name` (default \"srv on <hostname>\"); implies -mdns")
+	flag.StringVar(&bindAddr, "bind", "127.0.0.1", "listener socket's bind address")
+	flag.StringVar(&certFile, "cert", "", "path to SSL/TLS certificate file")
+	flag.StringVar(&keyFile, "key", "", "path to SSL/TLS key file")
+	flag.BoolVar(&browseArchives, "archives", true, "let clients browse into zip and tar files")
+	flag.BoolVar(&write, "write", false, "allow uploading, deleting and moving files, and creating directories")
+	flag.StringVar(&s3opts.Endpoint, "s3-endpoint", "", "base URL of the S3-compatible server for s3:// roots (default AWS)")
+	flag.StringVar(&s3opts.Region, "s3-region", os.Getenv("AWS_REGION"), "region of s3:// roots (default us-east-1)")
+	flag.BoolVar(&cleanURLs, "clean-urls", false, "serve /page from page.html if there's no file called page")
+	flag.BoolVar(&stripHTML, "strip-html", false, "redirect /page.html to /page and /index.html to /; implies -clean-urls")
+	flag.Var(spa, "spa", "serve `[/prefix=]file` in place of missing files under prefix (default /), for single-page apps; repeatable")
+	flag.StringVar(&notFoundPage, "404", "", "serve `file` as the page for 404 errors")
+	flag.Var(errorPages, "error-page", "serve `status=file` as the page for errors with that status; repeatable")
+	flag.Var(&rules, "redirect", "redirect or rewrite requests by a `rule` \"from to [status][!]\" in _redirects syntax; repeatable")
+	flag.StringVar(&redirectsFile, "redirects", "_redirects", "read redirect and rewrite rules from `file` in the served directory, if it exists")
+	flag.Var(&headers, "header", "set `[pattern ]Name: value` on responses for paths matching pattern (default /*), or remove it if value is empty; repeatable")
+	flag.StringVar(&headersFile, "headers", "_headers", "read response headers for paths from `file` in the served directory, if it exists")
+	flag.StringVar(&corsOrigins, "cors", "*", "allow cross-origin requests from comma-separated `origins`, such as https://*.example.com, or \"off\"")
+	flag.Var((*listFlag)(&cors.Methods), "cors-methods", "comma-separated `methods` to allow cross-origin (default those supported)")
+	cors.Headers = []string{"Content-Type", "Authorization"}
+	flag.Var((*listFlag)(&cors.Headers), "cors-headers", "comma-separated request `headers` to allow cross-origin, or *")
+	flag.Var((*listFlag)(&cors.ExposeHeaders), "cors-expose", "comma-separated response `headers` to expose cross-origin")
+	flag.BoolVar(&cors.Credentials, "cors-credentials", false, "allow cross-origin requests with credentials from the -cors origins")
+	flag.DurationVar(&cors.MaxAge, "cors-max-age", 0, "let browsers cache CORS preflights for `duration`")
+	flag.Var(&proxies, "proxy", "forward requests under `/prefix=url` to another server; repeatable")
+	flag.Var(proxyHeader, "proxy-header", "set `Name: value` on proxied requests, or remove it if value is empty; repeatable")
+	flag.Var(proxyResponseHeader, "proxy-response-header", "set `Name: value` on proxied responses, or remove it if value is empty; repeatable")
+	flag.DurationVar(&proxyTimeout, "proxy-timeout", 30*time.Second, "give up on proxied requests if the backend doesn't respond within `duration`")
+	flag.BoolVar(&liveReload, "livereload", false, "reload HTML pages in the browser when files change")
+	flag.BoolVar(&liveReloadCSS, "livereload-css", false, "swap in changed stylesheets without reloading; implies -livereload")
+	flag.BoolVar(&watch, "watch", false, "stream changes under directories requested with ?watch=1, and update listings live")
+	flag.BoolVar(&follow, "follow", false, "stream what's appended to files requested with ?follow=1, like tail -f")
+	flag.StringVar(&stdinName, "stdin", "", "serve what's piped to srv as a download called `name`, then exit")
+	flag.BoolVar(&fifos, "fifos", false, "serve named pipes in the served directory as downloads of what's written to them")
+	flag.StringVar(&onceFile, "once", "", "serve just `path`, a file or a directory as a zip, until it's been downloaded, then exit")
+	flag.IntVar(&maxDownloads, "max-downloads", 1, "with -stdin or -once, serve the download this many times")
+	flag.DurationVar(&expire, "expire", 0, "exit after `duration`")
+	flag.BoolVar(&gitHTTP, "git-http", false, "make git repositories in the served directory cloneable over HTTP")
+	flag.StringVar(&gitRev, "git-rev", "", "serve the tree of git `revision` of the repository being served")
+	flag.StringVar(&s3API, "s3-api", "", "serve an S3-compatible API over the served directory under a `/prefix or [host]:port`")
+	flag.Var(&vhosts, "vhost", "serve `host=dir[,cert,key][,option=value...]` for requests to host, with its own write, cors, spa, 404 and error-page options if given; repeatable")
+	flag.Parse()
+	if quiet {
+		log.SetFlags(0)
+		log.SetOutput(io.Discard)
+	}
+
+	if s3opts.Region == "" {
+		s3opts.Region = "us-east-1"
+	}
+	if s3opts.Endpoint == "" {
+		s3opts.Endpoint = "https://s3." + s3opts.Region + ".amazonaws.com"
+	}
+
+	if onceFile != "" {
+		// Sharing a file is the point, so listen on all interfaces unless
+		// told otherwise.
+		bindSet := false
+		flag.Visit(func(f *flag.Flag) { bindSet = bindSet || f.Name == "bind" })
+		if !bindSet {
+			bindAddr = "0.0.0.0"
+		}
+	}
+
+	listenAddr := net.JoinHostPort(bindAddr, port)
+	_, err := net.ResolveTCPAddr("tcp", listenAddr)
+	if err != nil {
+		die("Could not resolve the address to listen to: %s", listenAddr)
+	}
+
+	srvDir := "."
+	posArgs := flag.Args()
+
+	if len(posArgs) > 0 {
+		srvDir = posArgs[0]
+	}
+	switch sandboxOpts.landlock {
+	case "", "ro", "rw":
+	default:
+		die("-landlock must be ro or rw.")
+	}
+	if sandboxOpts.landlock != "" {
+		if err := checkLandlock(); err != nil {
+			die("%s.", err)
+		}
+	}
+	if sandboxOpts.landlock == "ro" && vhosts.anyWrite(write) {
+		die("-landlock ro doesn't allow -write; use -landlock rw.")
+	}
+	if (gitHTTP || gitRev != "") && (chroot || sandboxOpts.landlock != "" || seccomp) {
+		die("-git-http and -git-rev run git, which -chroot, -landlock and -seccomp don't allow.")
+	}
+	if chroot && (len(vhosts) > 0 || stdinName != "" || onceFile != "") {
+		die("-chroot needs a single directory to serve.")
+	}
+	if chroot && write && s3API != "" {
+		die("-chroot leaves no temporary directory for -s3-api to keep uploads in.")
+	}
+
+	var root fs.FS
+	if chroot {
+		// Paths are relative to the working directory, which is the
+		// served directory once chrooted. Nothing opens them before then.
+		checkDir(srvDir)
+		root = srv.DirFS(".")
+		sandboxOpts.chroot = srvDir
+	} else if gitRev != "" {
+		checkDir(srvDir)
+		g, err := srv.NewGitFS(srvDir, gitRev)
+		if err != nil {
+			die("Could not read revision %s: %s", gitRev, err)
+		}
+		root = g
+		log.Printf("\tServing revision %s (%.12s)", gitRev, g.Commit())
+	} else {
+		root = openRoot(srvDir)
+	}
+	defaultCORS, err := corsPolicy(corsOrigins, cors)
+	if err != nil {
+		die("%s.", err)
+	}
+	for i := range proxies {
+		proxies[i].Header = http.Header(proxyHeader)
+		proxies[i].ResponseHeader = http.Header(proxyResponseHeader)
+		proxies[i].Timeout = proxyTimeout
+	}
+	if notFoundPage != "" {
+		errorPages[http.StatusNotFound] = notFoundPage
+	}
+	opts := srv.Options{
+		Log:            log.Default(),
+		BrowseArchives: browseArchives,
+		Write:          write,
+		CleanURLs:      cleanURLs || stripHTML,
+		StripHTML:      stripHTML,
+		SPA:            spa,
+		ErrorPages:     errorPages,
+		GitHTTP:        gitHTTP,
+		Rules:          rules,
+		RulesFile:      redirectsFile,
+		Headers:        append(srv.DefaultHeaders[:len(srv.DefaultHeaders):len(srv.DefaultHeaders)], headers...),
+		HeadersFile:    headersFile,
+		CORS:           defaultCORS,
+		Proxies:        proxies,
+		LiveReload:     liveReload || liveReloadCSS,
+		LiveReloadCSS:  liveReloadCSS,
+		Watch:          watch,
+		Follow:         follow,
+		FIFOs:          fifos,
+	}
+	mux := &hostMux{
+		hosts:    make(map[string]http.Handler),
+		fallback: srv.New(root, opts),
+	}
+	certs := &certSelector{certs: make(map[string]*tls.Certificate)}
+	for _, v := range vhosts {
+		hostOpts, err := v.options(opts, cors)
+		if err != nil {
+			die("%s.", err)
+		}
+		mux.hosts[v.name] = srv.New(openRoot(v.dir), hostOpts)
+		if v.certFile != "" {
+			certs.certs[v.name] = loadCert(v.certFile, v.keyFile)
+		}
+	}
+
+	var handler http.Handler = mux
+	var done <-chan struct{}
+	download := "" // the name of a one-off download
+	if (stdinName != "" || onceFile != "") && maxDownloads < 1 {
+		die("-max-downloads must be at least 1.")
+	}
+	switch {
+	case stdinName != "" && onceFile != "":
+		die("-stdin and -once can't be used together.")
+	case stdinName != "":
+		sh := newStdinHandler(stdinName, os.Stdin, maxDownloads)
+		handler, done, download = sh, sh.done, sh.name
+		srvDir = "stdin as " + stdinName
+	case onceFile != "":
+		oh := newOnceHandler(onceFile, maxDownloads)
+		handler, done, download = oh, oh.done, oh.name
+		srvDir = onceFile
+	}
+	if expire > 0 {
+		finished, expired := done, time.After(expire)
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-finished:
+			case <-expired:
+				log.Printf("\tExpired after %s", expire)
+			}
+			close(stop)
+		}()
+		done = stop
+	}
+	http.Handle("/", handler)
+
+	for _, v := range vhosts {
+		log.Printf("\tServing %s for host %s", v.dir, v.name)
+	}
+	for _, p := range proxies {
+		log.Printf("\tProxying %s to %s", p.Prefix, p.Target)
+	}
+
+	var tlsConfig *tls.Config
+	if certFile != "" && keyFile != "" {
+		log.Printf("\tUsing SSL/TLS with certificate %s and key %s", certFile, keyFile)
+		certs.fallback = loadCert(certFile, keyFile)
+	}
+	if certs.fallback != nil || len(certs.certs) > 0 {
+		tlsConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+
+	ln, err := listen(bindAddr, port, portRetries, anyPort)
+	if err != nil {
+		die("Could not listen on %s: %s", listenAddr, err)
+	}
+	listenAddr = ln.Addr().String()
+	actualPort := ln.Addr().(*net.TCPAddr).Port
+	port = strconv.Itoa(actualPort)
+	proto, urlPath := "HTTP", "/"
+	if tlsConfig != nil {
+		proto = "HTTPS"
+	}
+	if download != "" {
+		urlPath += download
+	}
+	log.Printf("\tServing %s over %s on %s", srvDir, proto, listenAddr)
+	urls := localURLs(bindAddr, port, tlsConfig != nil, urlPath)
+	for _, u := range urls {
+		log.Printf("\t\t%s", u)
+	}
+	if printAddr || addrFile != "" {
+		info := listenInfo{Addr: listenAddr, Port: actualPort, URLs: urls}
+		if err := reportAddr(info, printAddr, addrFile); err != nil {
+			die("Could not report the address: %s", err)
+		}
+	}
+	if !quiet && (showQR || download != "") {
+		writeQR(os.Stderr, urls[0])
+	}
+	if mdns || mdnsName != "" {
+		advertiseMDNS(mdnsName, bindAddr, actualPort, tlsConfig != nil)
+	}
+	if openURL {
+		// Loopback addresses come last, and suit a local browser best.
+		if err := openBrowser(urls[len(urls)-1]); err != nil {
+			log.Printf("\tCould not open a browser: %s", err)
+		}
+	}
+
+	if s3API != "" {
+		api := srv.NewS3API(root, srv.S3APIOptions{
+			Credentials: s3Credentials(),
+			Write:       write,
+			Log:         log.Default(),
+		})
+		if strings.HasPrefix(s3API, "/") {
+			prefix := strings.TrimSuffix(s3API, "/")
+			http.Handle(prefix+"/", http.StripPrefix(prefix, api))
+			log.Printf("\tServing the S3 API under %s/", prefix)
+		} else {
+			host, apiPort, err := net.SplitHostPort(s3API)
+			if err != nil {
+				die("Invalid S3 API address %s: %s", s3API, err)
+			}
+			if host == "" {
+				host = bindAddr
+			}
+			apiAddr := net.JoinHostPort(host, apiPort)
+			apiLn, err := net.Listen("tcp", apiAddr)
+			if err != nil {
+				die("Could not listen on %s: %s", apiAddr, err)
+			}
+			log.Printf("\tServing the S3 API on %s", apiAddr)
+			go func() {
+				die(serve(apiLn, api, tlsConfig, nil).Error())
+			}()
+		}
+	}
+
+	sandboxOpts.seccomp = seccomp
+	if sandboxOpts.landlock != "" {
+		sandboxOpts.paths = localPaths(srvDir, onceFile, stdinName, vhosts)
+		if vhosts.anyWrite(write) && (s3API != "" || servesS3(srvDir, vhosts)) {
+			// Uploads to S3, and multipart ones to the S3 API, are kept
+			// there until they're done.
+			sandboxOpts.paths = append(sandboxOpts.paths, os.TempDir())
+		}
+	}
+	if sandboxOpts.enabled() {
+		// Load what's otherwise read from /etc on first use.
+		mime.TypeByExtension(".html")
+		x509.SystemCertPool()
+		if err := sandbox(sandboxOpts); err != nil {
+			die("Could not sandbox: %s", err)
+		}
+		if sandboxOpts.user != "" || sandboxOpts.group != "" {
+			log.Printf("\tRunning as uid %d, gid %d", os.Getuid(), os.Getgid())
+		}
+	}
+
+	if err := serve(ln, nil, tlsConfig, done); err != nil {
+		die(err.Error())
+	}
+}
+
+// serve serves h on ln, over TLS if tlsConfig is set. Once done is closed,
+// it waits for requests in progress and returns nil.
+func serve(ln net.Listener, h http.Handler, tlsConfig *tls.Config, done <-chan struct{}) error {
+	server := &http.Server{Handler: h, TLSConfig: tlsConfig}
+	shutdown := make(chan struct{})
+	go func() {
+		if done == nil {
+			return
+		}
+		<-done
+		server.Shutdown(context.Background())
+		close(shutdown)
+	}()
+	var err error
+	if tlsConfig != nil {
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		<-shutdown
+		return nil
+	}
+	return err
+}
+
+// s3Credentials reads the key the S3 API accepts from the environment.
+func s3Credentials() map[string]string {
+	id, secret := os.Getenv("SRV_S3_ACCESS_KEY_ID"), os.Getenv("SRV_S3_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		log.Printf("\tWarning: the S3 API is unauthenticated; set SRV_S3_ACCESS_KEY_ID and SRV_S3_SECRET_ACCESS_KEY")
+		return nil
+	}
+	return map[string]string{id: secret}
+}
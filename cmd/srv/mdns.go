@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// This is a minimal multicast DNS responder (RFC 6762) advertising srv as
+// a DNS-SD service (RFC 6763), so it shows up in LAN service browsers.
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN    = 1
+	dnsCacheFlush = 0x8000 // set on records only we answer for
+	dnsUnicast    = 0x8000 // set on questions wanting a unicast reply
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsService is a service instance to advertise, such as an _http._tcp
+// one.
+type mdnsService struct {
+	instance string // a single label, any text
+	service  string // e.g. _http._tcp
+	port     int
+	txt      []string
+}
+
+// mdnsResponder answers queries for its services.
+type mdnsResponder struct {
+	host     string // a single label, without .local
+	ips      []net.IP
+	services []mdnsService
+}
+
+type dnsRecord struct {
+	name  []string // labels
+	typ   uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+// systemHostname returns the first label of the system's host name.
+func systemHostname() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "localhost"
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// advertise probes for unique names, then answers queries and announces
+// the services on the LAN, until something goes wrong.
+func (m *mdnsResponder) advertise() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := multicastLoopback(conn); err != nil {
+		return err
+	}
+
+	if err := m.probe(conn); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	for _, s := range m.services {
+		log.Printf("\tAdvertising %q as %s.local on %s.local over mDNS", s.instance, s.service, m.host)
+	}
+
+	// Announce, as recommended, twice a second apart.
+	go func() {
+		for i := 0; i < 2; i++ {
+			if msg := m.response(0, nil, m.all()); msg != nil {
+				conn.WriteToUDP(msg, mdnsGroup)
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		id, questions, err := parseDNSQuery(buf[:n])
+		if err != nil {
+			continue
+		}
+		var answers []dnsRecord
+		unicast := from.Port != mdnsGroup.Port // a one-shot, legacy query
+		for _, q := range questions {
+			answers = append(answers, m.answer(q)...)
+			if q.class&dnsUnicast != 0 {
+				unicast = true
+			}
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		// Others probing for a name have to hear from us, however they ask.
+		probe := binary.BigEndian.Uint16(buf[8:n]) > 0
+		if unicast && !probe {
+			if from.Port != mdnsGroup.Port {
+				conn.WriteToUDP(m.response(id, questions, answers), from)
+			} else {
+				conn.WriteToUDP(m.response(0, nil, answers), from)
+			}
+			continue
+		}
+		conn.WriteToUDP(m.response(0, nil, answers), mdnsGroup)
+	}
+}
+
+// probe makes sure no one else on the LAN answers for the host and
+// instance names, before they're announced (RFC 6762, section 8.1). Names
+// someone else has are numbered until they're unique.
+func (m *mdnsResponder) probe(conn *net.UDPConn) error {
+	baseHost, baseInstances := m.host, make([]string, len(m.services))
+	for i, s := range m.services {
+		baseInstances[i] = s.instance
+	}
+	buf := make([]byte, 9000)
+	time.Sleep(time.Duration(rand.Intn(250)) * time.Millisecond)
+	for try := 2; try < 100; try++ {
+		conflict := false
+		for i := 0; i < 3 && !conflict; i++ {
+			if _, err := conn.WriteToUDP(m.probeQuery(), mdnsGroup); err != nil {
+				return err
+			}
+			conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+			for !conflict {
+				n, _, err := conn.ReadFromUDP(buf)
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				names, err := parseDNSAnswerNames(buf[:n])
+				if err != nil {
+					continue
+				}
+				for _, name := range names {
+					if sameName(name, m.hostName()) {
+						m.host = fmt.Sprintf("%s-%d", baseHost, try)
+						conflict = true
+					}
+					for j, s := range m.services {
+						if sameName(name, s.instanceName()) {
+							m.services[j].instance = fmt.Sprintf("%s (%d)", baseInstances[j], try)
+							conflict = true
+						}
+					}
+				}
+			}
+		}
+		if !conflict {
+			return nil
+		}
+	}
+	return errors.New("could not find unique names")
+}
+
+// probeQuery asks for the host and instance names, proposing our records
+// for them.
+func (m *mdnsResponder) probeQuery() []byte {
+	questions := []dnsQuestion{{m.hostName(), dnsTypeANY, dnsClassIN | dnsUnicast}}
+	var proposed []dnsRecord
+	for _, s := range m.services {
+		questions = append(questions, dnsQuestion{s.instanceName(), dnsTypeANY, dnsClassIN | dnsUnicast})
+		_, srv, txt := m.records(s)
+		proposed = append(proposed, srv, txt)
+	}
+	proposed = append(proposed, m.addresses()...)
+
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(msg[8:], uint16(len(proposed)))
+	for _, q := range questions {
+		msg = append(msg, encodeDNSName(q.name)...)
+		msg = appendUint16(msg, q.typ)
+		msg = appendUint16(msg, q.class)
+	}
+	for _, r := range proposed {
+		r.class &^= dnsCacheFlush
+		msg = appendRecord(msg, r)
+	}
+	return msg
+}
+
+type dnsQuestion struct {
+	name  []string
+	typ   uint16
+	class uint16
+}
+
+// parseDNSQuery returns the ID and questions of a DNS query.
+func parseDNSQuery(msg []byte) (uint16, []dnsQuestion, error) {
+	if len(msg) < 12 {
+		return 0, nil, errors.New("short message")
+	}
+	id := binary.BigEndian.Uint16(msg)
+	if msg[2]&0x80 != 0 {
+		return 0, nil, errors.New("not a query")
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	off := 12
+	var questions []dnsQuestion
+	for i := 0; i < qdcount; i++ {
+		name, n, err := parseDNSName(msg, off)
+		if err != nil {
+			return 0, nil, err
+		}
+		off = n
+		if off+4 > len(msg) {
+			return 0, nil, errors.New("short question")
+		}
+		questions = append(questions, dnsQuestion{
+			name:  name,
+			typ:   binary.BigEndian.Uint16(msg[off:]),
+			class: binary.BigEndian.Uint16(msg[off+2:]),
+		})
+		off += 4
+	}
+	return id, questions, nil
+}
+
+// parseDNSAnswerNames returns the names of the answers in a DNS response.
+func parseDNSAnswerNames(msg []byte) ([][]string, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("short message")
+	}
+	if msg[2]&0x80 == 0 {
+		return nil, errors.New("not a response")
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, n, err := parseDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n + 4
+	}
+	var names [][]string
+	for i := 0; i < ancount; i++ {
+		name, n, err := parseDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if n+10 > len(msg) {
+			return nil, errors.New("short record")
+		}
+		names = append(names, name)
+		off = n + 10 + int(binary.BigEndian.Uint16(msg[n+8:]))
+	}
+	return names, nil
+}
+
+// parseDNSName reads the name at off, returning it and the offset after.
+func parseDNSName(msg []byte, off int) ([]string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return nil, 0, errors.New("name out of bounds")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return labels, end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return nil, 0, errors.New("bad name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return nil, 0, errors.New("label out of bounds")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func sameName(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func labels(name string) []string {
+	return strings.Split(name, ".")
+}
+
+func (m *mdnsResponder) hostName() []string {
+	return []string{m.host, "local"}
+}
+
+func (s mdnsService) serviceName() []string {
+	return append(labels(s.service), "local")
+}
+
+func (s mdnsService) instanceName() []string {
+	return append([]string{s.instance}, s.serviceName()...)
+}
+
+// records returns the records describing a service instance: its PTR,
+// SRV and TXT.
+func (m *mdnsResponder) records(s mdnsService) (ptr, srv, txt dnsRecord) {
+	ptr = dnsRecord{s.serviceName(), dnsTypePTR, dnsClassIN, 4500, encodeDNSName(s.instanceName())}
+	var srvData []byte
+	srvData = appendUint16(srvData, 0) // priority
+	srvData = appendUint16(srvData, 0) // weight
+	srvData = appendUint16(srvData, uint16(s.port))
+	srvData = append(srvData, encodeDNSName(m.hostName())...)
+	srv = dnsRecord{s.instanceName(), dnsTypeSRV, dnsClassIN | dnsCacheFlush, 120, srvData}
+	var txtData []byte
+	for _, t := range s.txt {
+		txtData = append(txtData, byte(len(t)))
+		txtData = append(txtData, t...)
+	}
+	if len(txtData) == 0 {
+		txtData = []byte{0}
+	}
+	txt = dnsRecord{s.instanceName(), dnsTypeTXT, dnsClassIN | dnsCacheFlush, 4500, txtData}
+	return ptr, srv, txt
+}
+
+func (m *mdnsResponder) addresses() []dnsRecord {
+	var recs []dnsRecord
+	for _, ip := range m.ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			recs = append(recs, dnsRecord{m.hostName(), dnsTypeA, dnsClassIN | dnsCacheFlush, 120, []byte(ip4)})
+		}
+	}
+	return recs
+}
+
+// all returns every record, for announcements.
+func (m *mdnsResponder) all() []dnsRecord {
+	var recs []dnsRecord
+	for _, s := range m.services {
+		ptr, srv, txt := m.records(s)
+		recs = append(recs, ptr, srv, txt)
+	}
+	return append(recs, m.addresses()...)
+}
+
+// answer returns the records answering q, with those a browser is going
+// to want next.
+func (m *mdnsResponder) answer(q dnsQuestion) []dnsRecord {
+	var recs []dnsRecord
+	want := func(t uint16) bool { return q.typ == t || q.typ == dnsTypeANY }
+	if sameName(q.name, labels("_services._dns-sd._udp.local")) && want(dnsTypePTR) {
+		for _, s := range m.services {
+			recs = append(recs, dnsRecord{q.name, dnsTypePTR, dnsClassIN, 4500, encodeDNSName(s.serviceName())})
+		}
+	}
+	for _, s := range m.services {
+		ptr, srv, txt := m.records(s)
+		switch {
+		case sameName(q.name, s.serviceName()) && want(dnsTypePTR):
+			recs = append(recs, ptr, srv, txt)
+			recs = append(recs, m.addresses()...)
+		case sameName(q.name, s.instanceName()):
+			if want(dnsTypeSRV) {
+				recs = append(recs, srv)
+				recs = append(recs, m.addresses()...)
+			}
+			if want(dnsTypeTXT) {
+				recs = append(recs, txt)
+			}
+		}
+	}
+	if sameName(q.name, m.hostName()) && want(dnsTypeA) {
+		recs = append(recs, m.addresses()...)
+	}
+	return recs
+}
+
+// response builds a response message. Legacy queries get their ID and
+// questions back.
+func (m *mdnsResponder) response(id uint16, questions []dnsQuestion, answers []dnsRecord) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // response, authoritative
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+	for _, q := range questions {
+		msg = append(msg, encodeDNSName(q.name)...)
+		msg = appendUint16(msg, q.typ)
+		msg = appendUint16(msg, q.class&^dnsUnicast)
+	}
+	n := 0
+	seen := make(map[string]bool)
+	for _, r := range answers {
+		key := strings.ToLower(strings.Join(r.name, ".")) + string(rune(r.typ)) + string(r.data)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if id != 0 {
+			r.class &^= dnsCacheFlush // not for legacy resolvers
+		}
+		msg = appendRecord(msg, r)
+		n++
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(n))
+	return msg
+}
+
+func appendRecord(msg []byte, r dnsRecord) []byte {
+	msg = append(msg, encodeDNSName(r.name)...)
+	msg = appendUint16(msg, r.typ)
+	msg = appendUint16(msg, r.class)
+	msg = appendUint32(msg, r.ttl)
+	msg = appendUint16(msg, uint16(len(r.data)))
+	return append(msg, r.data...)
+}
+
+func encodeDNSName(name []string) []byte {
+	var b []byte
+	for _, l := range name {
+		if len(l) > 63 {
+			l = l[:63]
+		}
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// advertiseMDNS advertises the server on the LAN in the background. It
+// uses a host name of its own, srv-<hostname>.local, as the system's is
+// likely another responder's.
+func advertiseMDNS(instance string, bindAddr string, port int, https bool) {
+	host := systemHostname()
+	m := &mdnsResponder{host: "srv-" + host}
+	ip := net.ParseIP(bindAddr)
+	if ip == nil {
+		if addr, err := net.ResolveIPAddr("ip4", bindAddr); err == nil {
+			ip = addr.IP
+		}
+	}
+	if ip != nil && ip.IsLoopback() {
+		die("-mdns needs -bind to an address on the LAN, not %s.", bindAddr)
+	}
+	if ip != nil && !ip.IsUnspecified() {
+		m.ips = []net.IP{ip}
+	} else {
+		addrs, _ := net.InterfaceAddrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				m.ips = append(m.ips, ipnet.IP)
+			}
+		}
+	}
+	if instance == "" {
+		instance = "srv on " + host
+	}
+	service := "_http._tcp"
+	if https {
+		service = "_https._tcp"
+	}
+	m.services = append(m.services, mdnsService{instance: instance, service: service, port: port, txt: []string{"path=/"}})
+	go func() {
+		if err := m.advertise(); err != nil {
+			log.Printf("\tCould not advertise over mDNS: %s", err)
+		}
+	}()
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "net"
+
+// multicastLoopback would make what's sent to conn's group heard on this
+// host too.
+func multicastLoopback(conn *net.UDPConn) error {
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// onceHandler serves one file, or a directory as a zip, until it's been
+// downloaded in full a number of times.
+type onceHandler struct {
+	path  string // the file or directory
+	name  string // what it's downloaded as
+	dir   bool
+	limit int
+
+	mu    sync.Mutex
+	count int
+	done  chan struct{} // closed once the limit is reached
+}
+
+func newOnceHandler(p string, limit int) *onceHandler {
+	fi, err := os.Stat(p)
+	if err != nil {
+		die(err.Error())
+	}
+	h := &onceHandler{path: p, name: filepath.Base(p), limit: limit, done: make(chan struct{})}
+	if fi.IsDir() {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			die(err.Error())
+		}
+		h.name, h.dir = filepath.Base(abs)+".zip", true
+	} else if !fi.Mode().IsRegular() {
+		die("%s isn't a regular file or directory.", p)
+	}
+	return h
+}
+
+// downloaded counts a finished download.
+func (h *onceHandler) downloaded(remote string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count >= h.limit {
+		return
+	}
+	h.count++
+	log.Printf("\tSent %s to %s (%d of %d)", h.name, remote, h.count, h.limit)
+	if h.count == h.limit {
+		close(h.done)
+	}
+}
+
+func (h *onceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("\t%s [%s]: %s %s %s", r.RemoteAddr, r.UserAgent(), r.Method, r.Proto, r.Host+r.RequestURI)
+	if r.URL.Path == "/" {
+		http.Redirect(w, r, url.PathEscape(h.name), http.StatusFound)
+		return
+	}
+	if r.URL.Path != "/"+h.name {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case <-h.done:
+		http.Error(w, "already downloaded", http.StatusGone)
+		return
+	default:
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": h.name}))
+	if h.dir {
+		w.Header().Set("Content-Type", "application/zip")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := writeZip(w, h.path); err != nil {
+			log.Printf("\tFailed to send %s to %s: %s", h.name, r.RemoteAddr, err)
+			return
+		}
+		h.downloaded(r.RemoteAddr)
+		return
+	}
+
+	f, err := os.Open(h.path)
+	if err != nil {
+		http.Error(w, "failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to stat file", http.StatusInternalServerError)
+		return
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, h.name, fi.ModTime(), f)
+	if r.Method == http.MethodGet && cw.complete(fi.Size()) {
+		h.downloaded(r.RemoteAddr)
+	}
+}
+
+// countingWriter notes what a response was, to tell whether it delivered
+// the end of the file.
+type countingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (cw *countingWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
+
+// complete reports whether the whole response was sent and reached the
+// end of a file of the given size, so resumed downloads count once.
+func (cw *countingWriter) complete(size int64) bool {
+	length, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64)
+	if err != nil || cw.written != length {
+		return false
+	}
+	switch cw.status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		// bytes first-last/size
+		cr := cw.Header().Get("Content-Range")
+		i, j := strings.IndexByte(cr, '-'), strings.IndexByte(cr, '/')
+		if i < 0 || j < i {
+			return false
+		}
+		last, err := strconv.ParseInt(cr[i+1:j], 10, 64)
+		return err == nil && last == size-1
+	}
+	return false
+}
+
+// writeZip writes the directory tree at dir as a zip file, skipping
+// anything but regular files and directories.
+func writeZip(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+		hdr, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+			_, err = zw.CreateHeader(hdr)
+			return err
+		}
+		hdr.Method = zip.Deflate
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
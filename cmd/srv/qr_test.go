@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQRCapacity(t *testing.T) {
+	// The byte capacities of versions at level L, from the QR code
+	// specification.
+	tests := []struct{ n, ver int }{
+		{0, 1}, {17, 1}, {18, 2}, {32, 2}, {33, 3},
+		{134, 6}, {135, 7}, {230, 9}, {231, 10}, {271, 10},
+		{2953, 40},
+	}
+	for _, tt := range tests {
+		q, err := encodeQR(make([]byte, tt.n))
+		if err != nil {
+			t.Errorf("%d bytes: %s", tt.n, err)
+			continue
+		}
+		if ver := (q.size - 17) / 4; ver != tt.ver {
+			t.Errorf("%d bytes: version %d, want %d", tt.n, ver, tt.ver)
+		}
+	}
+	if _, err := encodeQR(make([]byte, 2954)); err == nil {
+		t.Error("2954 bytes fit in a QR code")
+	}
+}
+
+func TestQRAlignmentPositions(t *testing.T) {
+	tests := map[int][]int{
+		1:  nil,
+		2:  {6, 18},
+		7:  {6, 22, 38},
+		14: {6, 26, 46, 66},
+		15: {6, 26, 48, 70},
+		16: {6, 26, 50, 74},
+		32: {6, 34, 60, 86, 112, 138},
+		36: {6, 24, 50, 76, 102, 128, 154},
+		40: {6, 30, 58, 86, 114, 142, 170},
+	}
+	for ver, want := range tests {
+		if got := qrAlignmentPositions(ver); !reflect.DeepEqual(got, want) {
+			t.Errorf("version %d: %v, want %v", ver, got, want)
+		}
+	}
+}
+
+// The version 1-M example of "HELLO WORLD" from thonky.com's QR code
+// tutorial.
+func TestQRReedSolomon(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := qrRSRemainder(data, qrRSDivisor(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// qrFormat reads the format bits next to the top left finder pattern.
+func qrFormat(q *qrCode) int {
+	var bits int
+	at := func(i, x, y int) {
+		if q.modules[y][x] {
+			bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		at(i, 8, i)
+	}
+	at(6, 8, 7)
+	at(7, 8, 8)
+	at(8, 7, 8)
+	for i := 9; i < 15; i++ {
+		at(i, 14-i, 8)
+	}
+	return bits
+}
+
+func TestQRFormatBits(t *testing.T) {
+	// The format strings for level L, by mask, from the specification.
+	want := []int{0x77C4, 0x72F3, 0x7DAA, 0x789D, 0x662F, 0x6318, 0x6C41, 0x6976}
+	q, _ := encodeQR([]byte("x"))
+	for mask, w := range want {
+		q.drawFormatBits(mask)
+		if got := qrFormat(q); got != w {
+			t.Errorf("mask %d: format %015b, want %015b", mask, got, w)
+		}
+	}
+}
+
+func TestQRVersionBits(t *testing.T) {
+	q, _ := encodeQR(make([]byte, 135))
+	var bits int
+	for i := 0; i < 18; i++ {
+		if q.modules[i/3][q.size-11+i%3] {
+			bits |= 1 << i
+		}
+	}
+	if bits != 0x07C94 {
+		t.Errorf("version 7: version bits %018b, want %018b", bits, 0x07C94)
+	}
+}
+
+// qrMasks are the mask patterns, from the specification.
+var qrMasks = []func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// decodeQR reads back what encodeQR wrote, checking every block's error
+// correction.
+func decodeQR(t *testing.T, q *qrCode) []byte {
+	t.Helper()
+	ver := (q.size - 17) / 4
+	format := qrFormat(q) ^ 0x5412
+	if format>>13 != 1 {
+		t.Fatalf("error correction level %d, want L", format>>13)
+	}
+	invert := qrMasks[format>>10&7]
+
+	raw := make([]byte, qrRawCodewords(ver))
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(raw)*8 {
+					if q.modules[y][x] != invert(x, y) {
+						raw[i/8] |= 1 << (7 - i%8)
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	blocks, eccLen := qrBlocks[ver], qrECCPerBlock[ver]
+	short := blocks - len(raw)%blocks
+	dataLen := func(b int) int {
+		n := len(raw)/blocks - eccLen
+		if b >= short {
+			n++
+		}
+		return n
+	}
+	data := make([][]byte, blocks)
+	k := 0
+	for i := 0; i < dataLen(blocks-1); i++ {
+		for b := range data {
+			if i < dataLen(b) {
+				data[b] = append(data[b], raw[k])
+				k++
+			}
+		}
+	}
+	var codewords []byte
+	for b := range data {
+		ecc := make([]byte, eccLen)
+		for i := range ecc {
+			ecc[i] = raw[k+i*blocks+b]
+		}
+		if got := qrRSRemainder(data[b], qrRSDivisor(eccLen)); !bytes.Equal(got, ecc) {
+			t.Errorf("block %d: error correction %v, want %v", b, ecc, got)
+		}
+		codewords = append(codewords, data[b]...)
+	}
+
+	bit := 0
+	read := func(n int) int {
+		v := 0
+		for ; n > 0; n-- {
+			v = v<<1 | int(codewords[bit/8]>>(7-bit%8)&1)
+			bit++
+		}
+		return v
+	}
+	if mode := read(4); mode != 4 {
+		t.Fatalf("mode %d, want byte mode", mode)
+	}
+	n := read(8)
+	if ver >= 10 {
+		n = n<<8 | read(8)
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(read(8))
+	}
+	return out
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"http://192.168.1.10:8000/",
+		strings.Repeat("a", 17),
+		"https://example.com/" + strings.Repeat("long/path/", 14),
+		strings.Repeat("0123456789", 30),
+		strings.Repeat("\xff\x00", 1000),
+	} {
+		q, err := encodeQR([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeQR(t, q); string(got) != s {
+			t.Errorf("decoded %q, want %q", got, s)
+		}
+
+		// Try the masks encodeQR didn't pick too.
+		best := (qrFormat(q) ^ 0x5412) >> 10 & 7
+		q.applyMask(best)
+		for mask := 0; mask < 8; mask++ {
+			q.applyMask(mask)
+			q.drawFormatBits(mask)
+			if got := decodeQR(t, q); string(got) != s {
+				t.Errorf("mask %d: decoded %q, want %q", mask, got, s)
+			}
+			q.applyMask(mask)
+		}
+	}
+}
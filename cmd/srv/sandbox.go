@@ -0,0 +1,19 @@
+package main
+
+// sandboxOptions say how to confine srv once it's listening.
+type sandboxOptions struct {
+	// user and group to switch to; group defaults to the user's.
+	user, group string
+	// chroot is the directory to chroot into.
+	chroot string
+	// landlock restricts srv to paths, "ro" for reading and "rw" for
+	// writing too.
+	landlock string
+	paths    []string
+	// seccomp blocks system calls srv has no use for.
+	seccomp bool
+}
+
+func (o sandboxOptions) enabled() bool {
+	return o.user != "" || o.group != "" || o.chroot != "" || o.landlock != "" || o.seccomp
+}
@@ -0,0 +1,237 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock's system calls, numbered alike on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+)
+
+// Landlock's filesystem access rights.
+const (
+	llExecute = 1 << iota
+	llWriteFile
+	llReadFile
+	llReadDir
+	llRemoveDir
+	llRemoveFile
+	llMakeChar
+	llMakeDir
+	llMakeReg
+	llMakeSock
+	llMakeFifo
+	llMakeBlock
+	llMakeSym
+	llRefer    // since ABI 2
+	llTruncate // since ABI 3
+
+	llFileAccess = llExecute | llWriteFile | llReadFile | llTruncate
+)
+
+// landlockSystemPaths are read by the resolver long after start.
+var landlockSystemPaths = []string{"/etc/hosts", "/etc/resolv.conf", "/etc/nsswitch.conf"}
+
+const (
+	prSetNoNewPrivs = 38
+	prGetNoNewPrivs = 39
+	oPath           = 0x200000
+
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetErrno        = 0x00050000
+)
+
+// confine prepares the confinement only Linux has, Landlock and seccomp,
+// for sandbox. Landlock's rules hold open the paths, which chroot would
+// hide, so the ruleset is made first; restrict applies both once
+// privileges are dropped.
+func confine(opts sandboxOptions) (restrict func() error, err error) {
+	ruleset := -1
+	if opts.landlock != "" {
+		ruleset, err = landlockRuleset(opts.landlock == "rw", opts.paths)
+		if err != nil {
+			return nil, fmt.Errorf("landlock: %s", err)
+		}
+	}
+	return func() error {
+		if ruleset >= 0 {
+			defer syscall.Close(ruleset)
+			if err := landlockRestrict(ruleset); err != nil {
+				return fmt.Errorf("landlock: %s", err)
+			}
+		}
+		if opts.seccomp {
+			if err := installSeccomp(); err != nil {
+				return fmt.Errorf("seccomp: %s", err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// checkLandlock reports whether Landlock can be used, before anything is
+// done that needs undoing if it can't.
+func checkLandlock() error {
+	// AllThreadsSyscall refuses to work with cgo, which has threads of its
+	// own.
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prGetNoNewPrivs, 0, 0); errno == syscall.ENOTSUP {
+		return errors.New("Landlock needs srv built with CGO_ENABLED=0")
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion); errno != 0 {
+		return fmt.Errorf("Landlock isn't supported by the kernel: %s", errno)
+	}
+	return nil
+}
+
+// landlockRuleset makes a Landlock ruleset that lets srv read, or also
+// write, under paths, and read what the resolver needs.
+func landlockRuleset(write bool, paths []string) (int, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return -1, fmt.Errorf("not supported by the kernel: %s", errno)
+	}
+	handled := uint64(llMakeSym<<1 - 1)
+	if abi >= 2 {
+		handled |= llRefer
+	}
+	if abi >= 3 {
+		handled |= llTruncate
+	}
+	attr := struct{ handledAccessFS uint64 }{handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	access := uint64(llReadFile | llReadDir)
+	if write {
+		access = handled &^ (llExecute | llMakeChar | llMakeBlock)
+	}
+	for _, p := range paths {
+		if err := landlockAllow(int(fd), p, access); err != nil {
+			syscall.Close(int(fd))
+			return -1, fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	for _, p := range landlockSystemPaths {
+		if err := landlockAllow(int(fd), p, llReadFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			syscall.Close(int(fd))
+			return -1, fmt.Errorf("%s: %s", p, err)
+		}
+	}
+	return int(fd), nil
+}
+
+// landlockAllow adds a rule allowing access under path to a ruleset.
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= llFileAccess
+	}
+	// struct landlock_path_beneath_attr is packed.
+	var attr [12]byte
+	*(*uint64)(unsafe.Pointer(&attr[0])) = access
+	*(*int32)(unsafe.Pointer(&attr[8])) = int32(fd)
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr[0])), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// landlockRestrict enforces a ruleset on every thread of srv.
+func landlockRestrict(ruleset int) error {
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("needs srv built with CGO_ENABLED=0")
+		}
+		return errno
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// sockFilter is a classic BPF instruction.
+type sockFilter struct {
+	code   uint16
+	jt, jf uint8
+	k      uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// installSeccomp makes the system calls in deniedSyscalls, and any made
+// through another architecture's ABI, fail with EPERM on every thread.
+func installSeccomp() error {
+	if seccompArch == 0 {
+		return fmt.Errorf("not supported on %s", runtime.GOARCH)
+	}
+	const (
+		ldAbs = 0x20 // BPF_LD | BPF_W | BPF_ABS
+		jeq   = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+		jge   = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+		ret   = 0x06 // BPF_RET | BPF_K
+	)
+	n := uint8(len(deniedSyscalls))
+	prog := []sockFilter{
+		{code: ldAbs, k: 4}, // seccomp_data.arch
+		{code: jeq, jt: 1, k: seccompArch},
+		{code: ret, k: seccompRetErrno | uint32(syscall.EPERM)},
+		{code: ldAbs, k: 0}, // seccomp_data.nr
+		// The x32 ABI on amd64.
+		{code: jge, jt: n + 1, k: 0x40000000},
+	}
+	for i, nr := range deniedSyscalls {
+		prog = append(prog, sockFilter{code: jeq, jt: n - uint8(i), k: nr})
+	}
+	prog = append(prog,
+		sockFilter{code: ret, k: seccompRetAllow},
+		sockFilter{code: ret, k: seccompRetErrno | uint32(syscall.EPERM)},
+	)
+	fprog := sockFprog{len: uint16(len(prog)), filter: &prog[0]}
+
+	// no_new_privs is needed on this thread, and TSYNC sets it on the
+	// others along with the filter.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errno
+	}
+	r, _, errno := syscall.Syscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return errno
+	}
+	if r != 0 {
+		return fmt.Errorf("could not synchronize thread %d", r)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+const (
+	seccompArch = 0xc000003e // AUDIT_ARCH_X86_64
+	sysSeccomp  = 317
+)
+
+var deniedSyscalls = []uint32{
+	59,  // execve
+	322, // execveat
+	101, // ptrace
+	310, // process_vm_readv
+	311, // process_vm_writev
+	165, // mount
+	166, // umount2
+	155, // pivot_root
+	161, // chroot
+	272, // unshare
+	308, // setns
+	304, // open_by_handle_at
+	167, // swapon
+	168, // swapoff
+	169, // reboot
+	175, // init_module
+	313, // finit_module
+	176, // delete_module
+	246, // kexec_load
+	320, // kexec_file_load
+	321, // bpf
+	298, // perf_event_open
+	323, // userfaultfd
+	300, // fanotify_init
+	248, // add_key
+	249, // request_key
+	250, // keyctl
+	163, // acct
+	179, // quotactl
+	159, // adjtimex
+	164, // settimeofday
+	227, // clock_settime
+	172, // iopl
+	173, // ioperm
+	105, // setuid
+	106, // setgid
+	113, // setreuid
+	114, // setregid
+	117, // setresuid
+	119, // setresgid
+	116, // setgroups
+}
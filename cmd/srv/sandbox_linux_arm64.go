@@ -0,0 +1,48 @@
+package main
+
+const (
+	seccompArch = 0xc00000b7 // AUDIT_ARCH_AARCH64
+	sysSeccomp  = 277
+)
+
+var deniedSyscalls = []uint32{
+	221, // execve
+	281, // execveat
+	117, // ptrace
+	270, // process_vm_readv
+	271, // process_vm_writev
+	40,  // mount
+	39,  // umount2
+	41,  // pivot_root
+	51,  // chroot
+	97,  // unshare
+	268, // setns
+	265, // open_by_handle_at
+	224, // swapon
+	225, // swapoff
+	142, // reboot
+	105, // init_module
+	273, // finit_module
+	106, // delete_module
+	104, // kexec_load
+	294, // kexec_file_load
+	280, // bpf
+	241, // perf_event_open
+	282, // userfaultfd
+	262, // fanotify_init
+	217, // add_key
+	218, // request_key
+	219, // keyctl
+	89,  // acct
+	60,  // quotactl
+	171, // adjtimex
+	170, // settimeofday
+	112, // clock_settime
+	146, // setuid
+	144, // setgid
+	145, // setreuid
+	143, // setregid
+	147, // setresuid
+	149, // setresgid
+	159, // setgroups
+}
@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package main
+
+// seccomp filters aren't written for other architectures.
+const (
+	seccompArch = 0
+	sysSeccomp  = 0
+)
+
+var deniedSyscalls []uint32
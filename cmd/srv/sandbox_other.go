@@ -0,0 +1,16 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "errors"
+
+// sandbox would drop privileges and confine srv as opts say.
+func sandbox(opts sandboxOptions) error {
+	return errors.New("dropping privileges and sandboxing aren't supported on this platform")
+}
+
+// checkLandlock reports that Landlock isn't available.
+func checkLandlock() error {
+	return errors.New("Landlock is only supported on Linux")
+}
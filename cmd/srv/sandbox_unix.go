@@ -0,0 +1,77 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// sandbox drops privileges and confines srv as opts say. It has to run
+// after everything that needs root, or files outside the sandbox, is done.
+func sandbox(opts sandboxOptions) error {
+	uid, gid, err := lookupIDs(opts.user, opts.group)
+	if err != nil {
+		return err
+	}
+	restrict, err := confine(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.chroot != "" {
+		if err := syscall.Chroot(opts.chroot); err != nil {
+			return fmt.Errorf("chroot %s: %s", opts.chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return err
+		}
+	}
+
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %s", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %s", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %s", uid, err)
+		}
+	}
+	return restrict()
+}
+
+// lookupIDs resolves a user and a group, by name or number, to ids, or -1
+// if not given. The group defaults to the user's primary group.
+func lookupIDs(name, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			var idErr error
+			if u, idErr = user.LookupId(name); idErr != nil {
+				return -1, -1, err
+			}
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			var idErr error
+			if g, idErr = user.LookupGroupId(group); idErr != nil {
+				return -1, -1, err
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,22 @@
+//go:build aix || darwin || dragonfly || freebsd || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd netbsd openbsd solaris
+
+package main
+
+import "errors"
+
+// confine refuses Landlock and seccomp, which only Linux has.
+func confine(opts sandboxOptions) (func() error, error) {
+	if opts.landlock != "" {
+		return nil, checkLandlock()
+	}
+	if opts.seccomp {
+		return nil, errors.New("seccomp is only supported on Linux")
+	}
+	return func() error { return nil }, nil
+}
+
+// checkLandlock reports that Landlock isn't available.
+func checkLandlock() error {
+	return errors.New("Landlock is only supported on Linux")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+)
+
+// stdinHandler serves what's piped to srv as a single file, to the first
+// few clients to ask for it. One client reads straight from the pipe, and
+// more share a copy spooled to a temporary file.
+type stdinHandler struct {
+	name  string
+	in    io.Reader
+	limit int
+
+	mu     sync.Mutex
+	served int
+	wg     sync.WaitGroup
+	done   chan struct{} // closed when every download has finished
+
+	spool *spool
+}
+
+func newStdinHandler(name string, in io.Reader, limit int) *stdinHandler {
+	s := &stdinHandler{name: name, in: in, limit: limit, done: make(chan struct{})}
+	s.wg.Add(limit)
+	go func() {
+		s.wg.Wait()
+		close(s.done)
+	}()
+	if limit > 1 {
+		f, err := os.CreateTemp("", "srv-stdin-*")
+		if err != nil {
+			die("Could not create a file to buffer stdin in: %s", err)
+		}
+		os.Remove(f.Name())
+		s.spool = newSpool(f, in)
+	}
+	return s
+}
+
+func (s *stdinHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("\t%s [%s]: %s %s %s", r.RemoteAddr, r.UserAgent(), r.Method, r.Proto, r.Host+r.RequestURI)
+	if r.URL.Path == "/" {
+		http.Redirect(w, r, url.PathEscape(s.name), http.StatusFound)
+		return
+	}
+	if r.URL.Path != "/"+s.name {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(s.name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", ctype)
+		return
+	}
+	s.mu.Lock()
+	n := s.served
+	if n < s.limit {
+		s.served++
+	}
+	s.mu.Unlock()
+	if n >= s.limit {
+		http.Error(w, "already downloaded", http.StatusGone)
+		return
+	}
+	defer s.wg.Done()
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": s.name}))
+	var body io.Reader = s.in
+	if s.spool != nil {
+		body = s.spool.reader()
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("\tFailed to send %s to %s: %s", s.name, r.RemoteAddr, err)
+		return
+	}
+	log.Printf("\tSent %s to %s (%d of %d)", s.name, r.RemoteAddr, n+1, s.limit)
+}
+
+// spool copies a stream to a file that any number of readers can read
+// from the start while it's still being written.
+type spool struct {
+	f *os.File
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written int64
+	err     error // io.EOF once the stream has been copied
+}
+
+func newSpool(f *os.File, in io.Reader) *spool {
+	sp := &spool{f: f}
+	sp.cond = sync.NewCond(&sp.mu)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				if _, werr := f.Write(buf[:n]); werr != nil {
+					err = werr
+				}
+			}
+			sp.mu.Lock()
+			sp.written += int64(n)
+			if err != nil {
+				sp.err = err
+			}
+			sp.cond.Broadcast()
+			sp.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return sp
+}
+
+func (sp *spool) reader() io.Reader {
+	return &spoolReader{sp: sp}
+}
+
+type spoolReader struct {
+	sp  *spool
+	off int64
+}
+
+func (r *spoolReader) Read(p []byte) (int, error) {
+	sp := r.sp
+	sp.mu.Lock()
+	for r.off >= sp.written && sp.err == nil {
+		sp.cond.Wait()
+	}
+	written, err := sp.written, sp.err
+	sp.mu.Unlock()
+	if r.off >= written {
+		if err != io.EOF {
+			return 0, fmt.Errorf("reading stdin: %w", err)
+		}
+		return 0, io.EOF
+	}
+	if max := written - r.off; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, rerr := sp.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	if rerr == io.EOF {
+		rerr = nil
+	}
+	return n, rerr
+}
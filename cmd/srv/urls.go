@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"sort"
+)
+
+// localURLs returns the URLs at which a server listening on host and port
+// can be reached, with one per interface address if it's listening on
+// all of them. Loopback addresses come last.
+func localURLs(host, port string, https bool, path string) []string {
+	var ips []net.IP
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		addrs, _ := net.InterfaceAddrs()
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLinkLocalUnicast() || ipnet.IP.IsMulticast() {
+				continue
+			}
+			// An IPv4 listener can't be reached over IPv6.
+			if ip != nil && ip.To4() != nil && ipnet.IP.To4() == nil {
+				continue
+			}
+			ips = append(ips, ipnet.IP)
+		}
+	} else if ip != nil {
+		ips = append(ips, ip)
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		rank := func(ip net.IP) int {
+			switch {
+			case ip.IsLoopback():
+				return 2
+			case ip.To4() == nil:
+				return 1
+			}
+			return 0
+		}
+		return rank(ips[i]) < rank(ips[j])
+	})
+
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+	var urls []string
+	for _, ip := range ips {
+		u := url.URL{Scheme: scheme, Host: net.JoinHostPort(ip.String(), port), Path: path}
+		urls = append(urls, u.String())
+	}
+	if len(urls) == 0 {
+		u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port), Path: path}
+		urls = append(urls, u.String())
+	}
+	return urls
+}
+
+// openBrowser opens u in the desktop's browser.
+func openBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"srv"
+)
+
+// vhost is a name-based virtual host with its own served directory and,
+// optionally, its own certificate and options.
+type vhost struct {
+	name, dir         string
+	certFile, keyFile string
+
+	// Options set for the host alone; nil if it has the default
+	// directory's.
+	write      *bool
+	cors       []string
+	spa        spaFlag
+	errorPages errorPagesFlag
+}
+
+// vhostList collects repeated -vhost flags of the form
+// host=dir[,cert,key][,option=value...].
+type vhostList []vhost
+
+const vhostSyntax = "expected host=dir[,cert,key][,option=value...]"
+
+func (l *vhostList) String() string {
+	var names []string
+	for _, v := range *l {
+		names = append(names, v.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *vhostList) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return errors.New(vhostSyntax)
+	}
+	v := vhost{name: normalizeHost(s[:i])}
+	parts := strings.Split(s[i+1:], ",")
+	n := 0
+	for n < len(parts) && !strings.Contains(parts[n], "=") {
+		n++
+	}
+	switch n {
+	case 3:
+		v.certFile, v.keyFile = parts[1], parts[2]
+	case 1:
+	default:
+		return errors.New(vhostSyntax)
+	}
+	v.dir = parts[0]
+	if v.dir == "" {
+		return errors.New("empty directory for host " + v.name)
+	}
+	for _, opt := range parts[n:] {
+		if err := v.setOption(opt); err != nil {
+			return err
+		}
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+// setOption sets one of a host's options, given as option=value. They're
+// named, and take values, as the flags setting them for the default
+// directory do.
+func (v *vhost) setOption(opt string) error {
+	i := strings.IndexByte(opt, '=')
+	if i < 0 {
+		return errors.New(vhostSyntax)
+	}
+	name, value := opt[:i], opt[i+1:]
+	switch name {
+	case "write":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid write=%s for host %s", value, v.name)
+		}
+		v.write = &b
+	case "cors":
+		// Repeated for several origins, as commas separate options.
+		v.cors = append(v.cors, value)
+	case "spa":
+		if v.spa == nil {
+			v.spa = spaFlag{}
+		}
+		return v.spa.Set(value)
+	case "404":
+		value = "404=" + value
+		fallthrough
+	case "error-page":
+		if v.errorPages == nil {
+			v.errorPages = errorPagesFlag{}
+		}
+		return v.errorPages.Set(value)
+	default:
+		return fmt.Errorf("unknown option %s for host %s; expected write, cors, spa, 404 or error-page", name, v.name)
+	}
+	return nil
+}
+
+// options returns the options to serve the host with: opts, the default
+// directory's, but for those set for the host. cors is the default CORS
+// policy, whose origins the host may replace.
+func (v *vhost) options(opts srv.Options, cors srv.CORS) (srv.Options, error) {
+	if v.write != nil {
+		opts.Write = *v.write
+	}
+	if v.cors != nil {
+		policy, err := corsPolicy(strings.Join(v.cors, ","), cors)
+		if err != nil {
+			return opts, err
+		}
+		opts.CORS = policy
+	}
+	if v.spa != nil {
+		opts.SPA = v.spa
+	}
+	if v.errorPages != nil {
+		opts.ErrorPages = v.errorPages
+	}
+	return opts, nil
+}
+
+// anyWrite reports whether any host is writable, given whether the
+// default directory is.
+func (l vhostList) anyWrite(write bool) bool {
+	for _, v := range l {
+		if v.write != nil && *v.write {
+			return true
+		}
+	}
+	return write
+}
+
+// normalizeHost strips the port and any trailing dot from a Host header or
+// SNI name and lowercases it.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// hostMux routes requests by their Host header, falling back to the default
+// handler for unknown hosts.
+type hostMux struct {
+	hosts    map[string]http.Handler
+	fallback http.Handler
+}
+
+func (m *hostMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := m.hosts[normalizeHost(r.Host)]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	m.fallback.ServeHTTP(w, r)
+}
+
+// certSelector picks a certificate by SNI, falling back to the default one.
+type certSelector struct {
+	certs    map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+func (s *certSelector) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok := s.certs[normalizeHost(hello.ServerName)]; ok {
+		return cert, nil
+	}
+	if s.fallback != nil {
+		return s.fallback, nil
+	}
+	return nil, fmt.Errorf("no certificate for host %q", hello.ServerName)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"srv"
+)
+
+func TestVhostListSet(t *testing.T) {
+	var l vhostList
+	for _, s := range []string{
+		"Docs.Example.=/srv/docs",
+		"b.test:8443=/srv/b,b.pem,b-key.pem",
+		"c.test=/srv/c,write=true,cors=https://a.test,cors=https://b.test,spa=index.html,404=404.html,error-page=500=oops.html",
+	} {
+		if err := l.Set(s); err != nil {
+			t.Fatalf("Set(%q): %s", s, err)
+		}
+	}
+	yes := true
+	want := vhostList{
+		{name: "docs.example", dir: "/srv/docs"},
+		{name: "b.test", dir: "/srv/b", certFile: "b.pem", keyFile: "b-key.pem"},
+		{
+			name: "c.test", dir: "/srv/c", write: &yes,
+			cors:       []string{"https://a.test", "https://b.test"},
+			spa:        spaFlag{"/": "index.html"},
+			errorPages: errorPagesFlag{404: "404.html", 500: "oops.html"},
+		},
+	}
+	if len(l) != len(want) {
+		t.Fatalf("got %d hosts, want %d", len(l), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(l[i], want[i]) {
+			t.Errorf("host %d = %+v, want %+v", i, l[i], want[i])
+		}
+	}
+
+	for _, s := range []string{
+		"", "=/dir", "host", "host=", "host=dir,cert", "host=,write=true",
+		"host=dir,write=maybe", "host=dir,color=red", "host=dir,write=true,cert",
+		"host=dir,error-page=teapot",
+	} {
+		if err := new(vhostList).Set(s); err == nil {
+			t.Errorf("Set(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVhostOptions(t *testing.T) {
+	defaults := srv.Options{
+		Write:      true,
+		SPA:        map[string]string{"/": "app.html"},
+		ErrorPages: map[int]string{404: "missing.html"},
+		CORS:       &srv.CORS{Origins: []string{"*"}},
+	}
+	var l vhostList
+	for _, s := range []string{"a.test=/a", "b.test=/b,write=false,cors=https://b.test,404=b.html", "c.test=/c,cors=off"} {
+		if err := l.Set(s); err != nil {
+			t.Fatalf("Set(%q): %s", s, err)
+		}
+	}
+
+	a, err := l[0].options(defaults, srv.CORS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, defaults) {
+		t.Errorf("a.test options = %+v, want the defaults", a)
+	}
+
+	b, err := l[1].options(defaults, srv.CORS{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Write {
+		t.Error("b.test is writable")
+	}
+	if b.CORS == nil || !reflect.DeepEqual(b.CORS.Origins, []string{"https://b.test"}) || b.CORS.MaxAge != time.Hour {
+		t.Errorf("b.test CORS = %+v", b.CORS)
+	}
+	if !reflect.DeepEqual(b.ErrorPages, map[int]string{404: "b.html"}) {
+		t.Errorf("b.test error pages = %v", b.ErrorPages)
+	}
+	if !reflect.DeepEqual(b.SPA, defaults.SPA) {
+		t.Errorf("b.test SPA = %v, want the default", b.SPA)
+	}
+
+	c, err := l[2].options(defaults, srv.CORS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CORS != nil {
+		t.Errorf("c.test CORS = %+v, want none", c.CORS)
+	}
+
+	if _, err := l[1].options(defaults, srv.CORS{Credentials: true}); err != nil {
+		t.Errorf("listed origins with credentials: %s", err)
+	}
+	var star vhost
+	star.setOption("cors=*")
+	if _, err := star.options(defaults, srv.CORS{Credentials: true}); err == nil {
+		t.Error("credentials allowed with any origin")
+	}
+
+	if l.anyWrite(false) {
+		t.Error("no host is writable, but anyWrite reports one")
+	}
+	if !append(l, vhost{write: &defaults.Write}).anyWrite(false) {
+		t.Error("anyWrite misses a writable host")
+	}
+}
+
+func TestHostMux(t *testing.T) {
+	serve := func(s string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, s) })
+	}
+	m := &hostMux{hosts: map[string]http.Handler{"a.test": serve("a")}, fallback: serve("default")}
+	for host, want := range map[string]string{
+		"a.test":      "a",
+		"A.TEST:8080": "a",
+		"a.test.":     "a",
+		"b.test":      "default",
+		"":            "default",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		if w.Body.String() != want {
+			t.Errorf("host %q served by %s, want %s", host, w.Body, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package srv
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is a policy for cross-origin requests.
+type CORS struct {
+	// Origins are the origins allowed to make requests: "*" for any, an
+	// exact origin such as "https://example.com", or one with a wildcard
+	// for subdomains, "https://*.example.com".
+	Origins []string
+
+	// Methods are the methods allowed in requests. If empty, it's those
+	// the handler supports, less the ones that change files for origins
+	// only allowed by "*".
+	Methods []string
+
+	// Headers are the request headers allowed, or "*" for any.
+	Headers []string
+
+	// ExposeHeaders are the response headers scripts may read.
+	ExposeHeaders []string
+
+	// Credentials allows requests with cookies or HTTP authentication.
+	// It's ignored for origins only allowed by "*", as browsers refuse it.
+	Credentials bool
+
+	// MaxAge is how long browsers may cache the answer to a preflight.
+	MaxAge time.Duration
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin to send for origin,
+// if it's allowed.
+func (c *CORS) allowOrigin(origin string) (string, bool) {
+	any := false
+	for _, o := range c.Origins {
+		switch i := strings.IndexByte(o, '*'); {
+		case o == "*":
+			any = true
+		case i < 0:
+			if strings.EqualFold(o, origin) {
+				return origin, true
+			}
+		default:
+			prefix, suffix := o[:i], o[i+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) &&
+				!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+				return origin, true
+			}
+		}
+	}
+	if any {
+		return "*", true
+	}
+	return "", false
+}
+
+// writeMethods are the methods that change files, in write mode.
+var writeMethods = []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE"}
+
+// methods returns the methods the handler supports; with readOnly, only
+// those that don't change files.
+func (h *handler) methods(readOnly bool) []string {
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	if h.opts.GitHTTP {
+		methods = append(methods, http.MethodPost)
+	}
+	if h.opts.Write && !readOnly {
+		methods = append(methods, writeMethods...)
+	}
+	return methods
+}
+
+// cors applies the CORS policy to r, and answers it if it's a preflight
+// or other OPTIONS request.
+func (h *handler) cors(w http.ResponseWriter, r *http.Request) bool {
+	c := h.opts.CORS
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if c == nil || origin == "" {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", strings.Join(h.methods(false), ", "))
+			w.WriteHeader(http.StatusNoContent)
+			return true
+		}
+		return false
+	}
+
+	allow, ok := c.allowOrigin(origin)
+	if allow != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	if !ok {
+		if preflight {
+			h.error(w, r, "origin not allowed", http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allow)
+	if c.Credentials && allow != "*" {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(c.ExposeHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ", "))
+		}
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", strings.Join(h.methods(false), ", "))
+			w.WriteHeader(http.StatusNoContent)
+			return true
+		}
+		return false
+	}
+
+	methods := c.Methods
+	if len(methods) == 0 {
+		// Any page on the web may ask, so it mustn't change files.
+		methods = h.methods(allow == "*")
+	}
+	if !containsFold(methods, r.Header.Get("Access-Control-Request-Method")) {
+		h.error(w, r, "method not allowed", http.StatusForbidden)
+		return true
+	}
+	var requested []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				requested = append(requested, name)
+			}
+		}
+	}
+	if !containsFold(c.Headers, "*") {
+		for _, name := range requested {
+			if !containsFold(c.Headers, name) {
+				h.error(w, r, "header not allowed: "+name, http.StatusForbidden)
+				return true
+			}
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(requested) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAllowOrigin(t *testing.T) {
+	tests := []struct {
+		origins []string
+		origin  string
+		allow   string
+		ok      bool
+	}{
+		{[]string{"https://example.com"}, "https://example.com", "https://example.com", true},
+		{[]string{"https://example.com"}, "HTTPS://Example.com", "HTTPS://Example.com", true},
+		{[]string{"https://example.com"}, "http://example.com", "", false},
+		{[]string{"https://example.com"}, "https://example.com:8443", "", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com", "https://a.example.com", true},
+		{[]string{"https://*.example.com"}, "https://a.b.example.com", "https://a.b.example.com", true},
+		{[]string{"https://*.example.com"}, "https://example.com", "", false},
+		{[]string{"https://*.example.com"}, "https://.example.com", "", false},
+		{[]string{"https://*.example.com"}, "https://evil.com/.example.com", "", false},
+		{[]string{"https://*.example.com"}, "https://evil.com:1.example.com", "", false},
+		{[]string{"https://*.example.com"}, "https://a.example.com.evil.com", "", false},
+		{[]string{"*"}, "https://anything.test", "*", true},
+		{[]string{"*", "https://example.com"}, "https://example.com", "https://example.com", true},
+		{nil, "https://example.com", "", false},
+	}
+	for _, tt := range tests {
+		c := &CORS{Origins: tt.origins}
+		allow, ok := c.allowOrigin(tt.origin)
+		if allow != tt.allow || ok != tt.ok {
+			t.Errorf("%v allowing %s = %q, %v; want %q, %v", tt.origins, tt.origin, allow, ok, tt.allow, tt.ok)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := New(fstest.MapFS{"a": {}}, Options{
+		Write: true,
+		CORS: &CORS{
+			Origins: []string{"*", "https://app.example.com"},
+			Headers: []string{"Content-Type"},
+		},
+	})
+	tests := []struct {
+		origin, method, headers string
+		status                  int
+		allow                   string
+	}{
+		{"https://app.example.com", "PUT", "", 204, "https://app.example.com"},
+		{"https://app.example.com", "MOVE", "content-type", 204, "https://app.example.com"},
+		{"https://app.example.com", "GET", "X-Other", 403, "https://app.example.com"},
+		// Only "*" lets other origins in, and not to change files.
+		{"https://other.test", "GET", "", 204, "*"},
+		{"https://other.test", "PUT", "", 403, "*"},
+		{"https://other.test", "DELETE", "", 403, "*"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodOptions, "/a", nil)
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", tt.method)
+		if tt.headers != "" {
+			r.Header.Set("Access-Control-Request-Headers", tt.headers)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.origin, tt.method, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("%s %s: allowed origin %q, want %q", tt.origin, tt.method, got, tt.allow)
+		}
+		if w.Code == http.StatusNoContent && !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), tt.method) {
+			t.Errorf("%s %s: allowed methods %q", tt.origin, tt.method, w.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+}
+
+func TestCORSRequest(t *testing.T) {
+	h := New(fstest.MapFS{"a": {Data: []byte("a")}}, Options{
+		CORS: &CORS{
+			Origins:       []string{"https://*.example.com"},
+			ExposeHeaders: []string{"ETag"},
+			Credentials:   true,
+		},
+	})
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+	r.Header.Set("Origin", "https://a.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	for k, v := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://a.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Expose-Headers":    "ETag",
+		"Vary":                             "Origin",
+	} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s: %q, want %q", k, got, v)
+		}
+	}
+
+	r.Header.Set("Origin", "https://evil.test")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin: status %d, allowed origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
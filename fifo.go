@@ -0,0 +1,109 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// fifoPoll is how often a named pipe without a writer is checked again.
+const fifoPoll = 100 * time.Millisecond
+
+// serveFIFO streams what's next written to a named pipe, as a download, to
+// one client at a time.
+func (h *handler) serveFIFO(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) {
+	lfs, ok := fsys.(LocalFS)
+	if !ok {
+		h.error(w, r, "file isn't a regular file or directory", http.StatusForbidden)
+		return
+	}
+	p, err := lfs.LocalPath(name)
+	if err != nil {
+		h.error(w, r, fmt.Sprintf("failed to open file: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	setHeaders := func() {
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	}
+	if r.Method == http.MethodHead {
+		setHeaders()
+		return
+	}
+
+	h.fifoMu.Lock()
+	busy := h.fifoReaders[p]
+	if !busy {
+		if h.fifoReaders == nil {
+			h.fifoReaders = make(map[string]bool)
+		}
+		h.fifoReaders[p] = true
+	}
+	h.fifoMu.Unlock()
+	if busy {
+		h.error(w, r, "pipe is already being read", http.StatusConflict)
+		return
+	}
+	defer func() {
+		h.fifoMu.Lock()
+		delete(h.fifoReaders, p)
+		h.fifoMu.Unlock()
+	}()
+
+	// A blocking open would wait for a writer in the system call, holding
+	// a thread even once the client has gone.
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		h.error(w, r, fmt.Sprintf("failed to open file: %s", err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	ctx := r.Context()
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	started := false
+	for {
+		f.SetReadDeadline(time.Now().Add(fifoPoll))
+		n, err := f.Read(buf)
+		if n > 0 {
+			if !started {
+				setHeaders()
+				started = true
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		switch {
+		case err == io.EOF && !started:
+			// There's no writer yet.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fifoPoll):
+			}
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if ctx.Err() != nil {
+				return
+			}
+		case err != nil:
+			return
+		}
+	}
+}
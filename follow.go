@@ -0,0 +1,309 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// followPoll is how often a followed file is checked for growth.
+const followPoll = 250 * time.Millisecond
+
+const followViewer = `<head>
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<link rel="icon" href="data:,">
+<title>%s</title>
+<style>
+
+* {
+     font-family: monospace;
+}
+ body {
+     background-color: black;
+     color: white;
+}
+ pre {
+     white-space: pre-wrap;
+     word-break: break-all;
+}
+ label {
+     position: fixed;
+     top: 0;
+     right: 0;
+     padding: 4px;
+     background-color: black;
+     color: #ff3d98;
+}
+ .note {
+     color: #eeb9da;
+}
+
+</style>
+</head>
+<label><input type="checkbox" id="scroll" checked> auto-scroll</label>
+<pre id="log"></pre>
+<script>
+(function () {
+	var log = document.getElementById("log");
+	var scroll = document.getElementById("scroll");
+	function append(node) {
+		log.appendChild(node);
+		if (scroll.checked) {
+			window.scrollTo(0, document.body.scrollHeight);
+		}
+	}
+	function note(text) {
+		var span = document.createElement("span");
+		span.className = "note";
+		span.textContent = "--- " + text + " ---\n";
+		append(span);
+	}
+	var events = new EventSource(location.pathname + location.search);
+	events.onmessage = function (e) {
+		append(document.createTextNode(JSON.parse(e.data)));
+	};
+	events.addEventListener("truncated", function () {
+		note("file truncated");
+	});
+	events.addEventListener("rotated", function () {
+		note("file replaced");
+	});
+	events.onerror = function () {
+		if (events.readyState === EventSource.CLOSED) {
+			note("disconnected");
+		}
+	};
+})();
+</script>
+`
+
+// tailOffset returns the offset of the last n lines of the first size
+// bytes of f.
+func tailOffset(f io.ReaderAt, size int64, n int) int64 {
+	buf := make([]byte, 4096)
+	end := size
+	if end > 0 {
+		// A final newline ends the last line rather than starting another.
+		end--
+	}
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] == '\n' {
+				if n--; n <= 0 {
+					return start + int64(i) + 1
+				}
+			}
+		}
+		end = start
+	}
+	return 0
+}
+
+// runeEnd returns the length of p without an incomplete UTF-8 sequence at
+// its end.
+func runeEnd(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
+
+// follower reads what's appended to a file, noticing when it's truncated
+// or replaced by another, as when logs are rotated.
+type follower struct {
+	fsys   fs.FS
+	name   string
+	f      fs.File
+	fi     fs.FileInfo
+	offset int64
+}
+
+func (fl *follower) open() error {
+	f, err := fl.fsys.Open(fl.name)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, ok := f.(io.ReaderAt); !ok {
+		f.Close()
+		return fmt.Errorf("%s can't be followed", fl.name)
+	}
+	if fl.f != nil {
+		fl.f.Close()
+	}
+	fl.f, fl.fi, fl.offset = f, fi, 0
+	return nil
+}
+
+// replaced reports whether the file at the name isn't the one open, which
+// can only be told for local files.
+func (fl *follower) replaced() bool {
+	lfs, ok := fl.fsys.(LocalFS)
+	if !ok {
+		return false
+	}
+	p, err := lfs.LocalPath(fl.name)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(p)
+	return err == nil && !os.SameFile(fi, fl.fi)
+}
+
+// read reads what's been appended since the last read into buf. It
+// returns "truncated" or "rotated" if the file was, and started over.
+func (fl *follower) read(buf []byte) (int, string, error) {
+	n, err := fl.f.(io.ReaderAt).ReadAt(buf, fl.offset)
+	fl.offset += int64(n)
+	if n > 0 || (err != nil && err != io.EOF) {
+		if err == io.EOF {
+			err = nil
+		}
+		return n, "", err
+	}
+
+	if fl.replaced() {
+		// Anything written to the old file before it was replaced has
+		// been read, as that read hit its end.
+		if err := fl.open(); err != nil {
+			return 0, "", err
+		}
+		return 0, "rotated", nil
+	}
+	fi, err := fl.f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if fi.Size() < fl.offset {
+		fl.offset = 0
+		return 0, "truncated", nil
+	}
+	return 0, "", nil
+}
+
+// serveFollow streams the tail of a file and then whatever is appended
+// to it: as server-sent events of JSON strings, to a viewer page for
+// browsers, or as plain text.
+func (h *handler) serveFollow(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) {
+	accept := r.Header.Get("Accept")
+	sse := strings.Contains(accept, "text/event-stream")
+	if !sse && strings.Contains(accept, "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, followViewer, html.EscapeString(path.Base(name)))
+		return
+	}
+
+	fl := &follower{fsys: fsys, name: name}
+	if err := fl.open(); err != nil {
+		h.error(w, r, fmt.Sprintf("failed to open file: %s", err), http.StatusInternalServerError)
+		return
+	}
+	defer func() { fl.f.Close() }()
+	lines := 10
+	if n, err := strconv.Atoi(r.URL.Query().Get("lines")); err == nil && n >= 0 {
+		lines = n
+	}
+	fl.offset = fl.fi.Size()
+	if lines > 0 {
+		fl.offset = tailOffset(fl.f.(io.ReaderAt), fl.fi.Size(), lines)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.error(w, r, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	flusher.Flush()
+
+	buf := make([]byte, 32*1024)
+	var held []byte // the start of a character split across reads
+	idle := time.Duration(0)
+	for {
+		n, change, err := fl.read(buf)
+		if err != nil {
+			if sse {
+				fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+			}
+			return
+		}
+		switch {
+		case n > 0:
+			if sse {
+				// A character split across reads would be mangled into
+				// U+FFFD by JSON, so its start is held back for the rest.
+				p := append(held, buf[:n]...)
+				k := runeEnd(p)
+				held = append(held[:0:0], p[k:]...)
+				if k > 0 {
+					data, _ := json.Marshal(string(p[:k]))
+					fmt.Fprintf(w, "data: %s\n\n", data)
+				}
+			} else {
+				w.Write(buf[:n])
+			}
+			flusher.Flush()
+			idle = 0
+			continue
+		case change != "" && sse:
+			if len(held) > 0 {
+				// The rest isn't coming.
+				data, _ := json.Marshal(string(held))
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				held = nil
+			}
+			fmt.Fprintf(w, "event: %s\ndata: \n\n", change)
+			flusher.Flush()
+			continue
+		case change != "":
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(followPoll):
+		}
+		if idle += followPoll; sse && idle >= 30*time.Second {
+			io.WriteString(w, ": keepalive\n\n")
+			flusher.Flush()
+			idle = 0
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package srv
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRuneEnd(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"a\xc3\xa9", 3},
+		{"a\xc3", 1},
+		{"a\xe2\x82", 1},
+		{"\xf0\x9f\x98", 0},
+		{"a\xf0\x9f\x98\x80", 5},
+		// Invalid bytes won't ever be completed.
+		{"a\xff", 2},
+		{"a\x80", 2},
+	}
+	for _, tt := range tests {
+		if got := runeEnd([]byte(tt.in)); got != tt.want {
+			t.Errorf("runeEnd(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFollowSplitRune(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "log")
+	if err := os.WriteFile(name, []byte("caf\xc3"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(DirFS(dir), Options{Follow: true}))
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/log?follow=1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), "data: ") {
+				return lines.Text()
+			}
+		}
+		t.Fatal(lines.Err())
+		return ""
+	}
+	if got := next(); got != `data: "caf"` {
+		t.Errorf("got %s, want the complete characters", got)
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\xa9!\n")
+	f.Close()
+	if got := next(); got != `data: "é!\n"` {
+		t.Errorf("got %s, want the rest of the character", got)
+	}
+}
+
+// readCounter counts the reads made of its files.
+type readCounter struct {
+	fstest.MapFS
+	reads int64
+}
+
+type countedFile struct {
+	fs.File
+	c *readCounter
+}
+
+func (c *readCounter) Open(name string) (fs.File, error) {
+	f, err := c.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countedFile{f, c}, nil
+}
+
+func (f countedFile) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&f.c.reads, 1)
+	return f.File.(io.ReaderAt).ReadAt(p, off)
+}
+
+// A file ending in part of a character is waited on like any other.
+func TestFollowPartialRuneWaits(t *testing.T) {
+	fsys := &readCounter{MapFS: fstest.MapFS{"log": {Data: []byte("caf\xc3")}}}
+	ts := httptest.NewServer(New(fsys, Options{Follow: true}))
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/log?follow=1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	time.Sleep(5 * followPoll)
+	if n := atomic.LoadInt64(&fsys.reads); n > 20 {
+		t.Errorf("%d reads in %s, want a few", n, 5*followPoll)
+	}
+}
+
+// A file replaced while part of a character is held back is still noticed.
+func TestFollowPartialRuneRotated(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "log")
+	if err := os.WriteFile(name, []byte("caf\xc3"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(DirFS(dir), Options{Follow: true}))
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/log?follow=1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	next := func(want string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("stream ended waiting for %s", want)
+				}
+				if line == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s", want)
+			}
+		}
+	}
+	next(`data: "caf"`)
+	if err := os.WriteFile(filepath.Join(dir, "new"), []byte("new\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "new"), name); err != nil {
+		t.Fatal(err)
+	}
+	next("event: rotated")
+	next(`data: "new\n"`)
+}
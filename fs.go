@@ -0,0 +1,438 @@
+package srv
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// LstatFS is implemented by filesystems that can describe a symlink itself
+// rather than its target. The handler refuses to serve symlinks it finds
+// this way.
+type LstatFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// Annotator is implemented by filesystems with a note to show alongside
+// each file in directory listings, like GitFS's last commits.
+type Annotator interface {
+	fs.FS
+	Annotate(name string) string
+}
+
+// LocalFS is implemented by filesystems backed by local directories, for
+// features that need a file's real path, like git's smart protocol.
+type LocalFS interface {
+	fs.FS
+	LocalPath(name string) (string, error)
+}
+
+// WriteFS is implemented by filesystems that can store files, which the
+// handler needs in write mode.
+type WriteFS interface {
+	fs.FS
+	// Create starts writing the named file. Its parent directory must
+	// exist.
+	Create(name string) (Upload, error)
+	// Mkdir creates a directory, whose parent must exist.
+	Mkdir(name string) error
+	// Remove removes a file or an empty directory.
+	Remove(name string) error
+}
+
+// RenameFS is implemented by writable filesystems that can move files and
+// directories, which the handler needs for MOVE requests.
+type RenameFS interface {
+	WriteFS
+	// Rename moves oldname to newname, replacing any file there.
+	Rename(oldname, newname string) error
+}
+
+// RemoveAllFS is implemented by writable filesystems that can remove a
+// directory tree at once. Others have it removed file by file.
+type RemoveAllFS interface {
+	WriteFS
+	RemoveAll(name string) error
+}
+
+// Upload is a file being written. The file appears, replacing any previous
+// one of the same name, when the upload is closed; an aborted upload leaves
+// no trace.
+type Upload interface {
+	io.Writer
+	Close() error
+	Abort() error
+}
+
+func lstat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if fsys, ok := fsys.(LstatFS); ok {
+		return fsys.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// DirFS is a filesystem rooted at a local directory. Unlike os.DirFS, it
+// implements LstatFS.
+type DirFS string
+
+func (d DirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+func (d DirFS) LocalPath(name string) (string, error) {
+	return d.join("localpath", name)
+}
+
+func (d DirFS) Open(name string) (fs.File, error) {
+	p, err := d.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (d DirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (d DirFS) Lstat(name string) (fs.FileInfo, error) {
+	p, err := d.join("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+func (d DirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := d.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (d DirFS) Create(name string) (Upload, error) {
+	p, err := d.join("create", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".srv-upload-*")
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, &fs.PathError{Op: "create", Path: name, Err: err}
+	}
+	return &fileUpload{File: f, dest: p}, nil
+}
+
+func (d DirFS) Mkdir(name string) error {
+	p, err := d.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, 0755)
+}
+
+func (d DirFS) Remove(name string) error {
+	p, err := d.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (d DirFS) Rename(oldname, newname string) error {
+	oldp, err := d.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newp, err := d.join("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldp, newp)
+}
+
+func (d DirFS) RemoveAll(name string) error {
+	p, err := d.join("removeall", name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// fileUpload is written to a temporary file next to its destination and
+// renamed into place when closed.
+type fileUpload struct {
+	*os.File
+	dest string
+}
+
+func (u *fileUpload) Close() error {
+	if err := u.File.Close(); err != nil {
+		os.Remove(u.Name())
+		return err
+	}
+	if err := os.Chmod(u.Name(), 0644); err != nil {
+		os.Remove(u.Name())
+		return err
+	}
+	return os.Rename(u.Name(), u.dest)
+}
+
+func (u *fileUpload) Abort() error {
+	u.File.Close()
+	return os.Remove(u.Name())
+}
+
+// Overlay serves a stack of filesystems as one namespace. Lookups fall
+// through from the first (top) layer to the last; directory listings merge
+// entries, with upper layers winning on name clashes.
+type Overlay []fs.FS
+
+func (o Overlay) find(name string, stat func(fs.FS, string) (fs.FileInfo, error)) (fs.FileInfo, error) {
+	var firstErr error
+	for _, layer := range o {
+		fi, err := stat(layer, name)
+		if err == nil {
+			return fi, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil, firstErr
+}
+
+func (o Overlay) Stat(name string) (fs.FileInfo, error) {
+	return o.find(name, fs.Stat)
+}
+
+func (o Overlay) Lstat(name string) (fs.FileInfo, error) {
+	return o.find(name, lstat)
+}
+
+func (o Overlay) Open(name string) (fs.File, error) {
+	for i, layer := range o {
+		f, err := layer.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil || !fi.IsDir() {
+			return f, err
+		}
+		entries, err := o[i:].ReadDir(name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &overlayDir{File: f, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o Overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	found := false
+	for _, layer := range o {
+		if fi, err := fs.Stat(layer, name); err != nil || !fi.IsDir() {
+			continue
+		}
+		found = true
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range layerEntries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// LocalPath returns the path of name in the topmost local layer that has
+// it.
+func (o Overlay) LocalPath(name string) (string, error) {
+	for _, layer := range o {
+		lfs, ok := layer.(LocalFS)
+		if !ok {
+			continue
+		}
+		if _, err := lstat(layer, name); err == nil {
+			return lfs.LocalPath(name)
+		}
+	}
+	return "", &fs.PathError{Op: "localpath", Path: name, Err: fs.ErrNotExist}
+}
+
+// top returns the top layer if it's writable. Writes go to the top layer,
+// leaving the ones below untouched.
+func (o Overlay) top(op, name string) (WriteFS, error) {
+	if len(o) > 0 {
+		if top, ok := o[0].(WriteFS); ok {
+			return top, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+func (o Overlay) Create(name string) (Upload, error) {
+	top, err := o.top("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.copyUpDirs(top, name); err != nil {
+		return nil, err
+	}
+	return top.Create(name)
+}
+
+func (o Overlay) Mkdir(name string) error {
+	top, err := o.top("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if err := o.copyUpDirs(top, name); err != nil {
+		return err
+	}
+	return top.Mkdir(name)
+}
+
+// copyUpDirs makes the directories name is in in the top layer, where
+// they're only in the layers below.
+func (o Overlay) copyUpDirs(top WriteFS, name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	if _, err := lstat(o[0], dir); err == nil {
+		return nil
+	}
+	if fi, err := o.Stat(dir); err != nil || !fi.IsDir() {
+		return nil // for the top layer to report
+	}
+	if err := o.copyUpDirs(top, dir); err != nil {
+		return err
+	}
+	return top.Mkdir(dir)
+}
+
+func (o Overlay) Remove(name string) error {
+	top, err := o.topFile("remove", name)
+	if err != nil {
+		return err
+	}
+	return top.Remove(name)
+}
+
+// errReadOnlyLayer is the error for changing what's in a lower layer.
+var errReadOnlyLayer = readOnlyLayerError{}
+
+type readOnlyLayerError struct{}
+
+func (readOnlyLayerError) Error() string        { return "read-only layer" }
+func (readOnlyLayerError) Is(target error) bool { return target == fs.ErrPermission }
+
+// onlyInTop reports whether name is in the top layer, the only one that
+// can be changed, and in no layer below, which would show through if it
+// were removed.
+func (o Overlay) onlyInTop(name string) bool {
+	if len(o) == 0 {
+		return false
+	}
+	if _, err := lstat(o[0], name); err != nil {
+		return false
+	}
+	_, err := lstat(o[1:], name)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// topFile returns the top layer, like top, for removing name or moving it
+// away, which it can't be from a layer below.
+func (o Overlay) topFile(op, name string) (WriteFS, error) {
+	top, err := o.top(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := lstat(o[1:], name); err == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: errReadOnlyLayer}
+	}
+	return top, nil
+}
+
+// Rename moves a file within the top layer.
+func (o Overlay) Rename(oldname, newname string) error {
+	top, err := o.topFile("rename", oldname)
+	if err != nil {
+		return err
+	}
+	rfs, ok := top.(RenameFS)
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: errors.New("renaming isn't supported")}
+	}
+	if err := o.copyUpDirs(top, newname); err != nil {
+		return err
+	}
+	return rfs.Rename(oldname, newname)
+}
+
+func (o Overlay) RemoveAll(name string) error {
+	top, err := o.topFile("removeall", name)
+	if err != nil {
+		return err
+	}
+	return removeAll(top, name)
+}
+
+// overlayDir is a directory of the top layer whose entries are merged with
+// those of the layers below it.
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
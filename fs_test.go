@@ -0,0 +1,90 @@
+package srv
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "shared"), []byte("top"), 0o666)
+	os.Mkdir(filepath.Join(dir, "d"), 0o777)
+	os.WriteFile(filepath.Join(dir, "d", "t"), nil, 0o666)
+	o := Overlay{DirFS(dir), fstest.MapFS{
+		"shared":   {Data: []byte("bottom")},
+		"lower":    {Data: []byte("lower")},
+		"d/b":      {},
+		"low/er/x": {},
+	}}
+
+	if data, err := fs.ReadFile(o, "shared"); err != nil || string(data) != "top" {
+		t.Errorf("shared holds %q, %v; want the top layer's", data, err)
+	}
+	if data, err := fs.ReadFile(o, "lower"); err != nil || string(data) != "lower" {
+		t.Errorf("lower holds %q, %v", data, err)
+	}
+	entries, err := fs.ReadDir(o, "d")
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"b", "t"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("d lists %v, %v; want %v", names, err, want)
+	}
+	if _, err := o.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat of a missing file: %v", err)
+	}
+
+	// Only the top layer can be changed.
+	if err := o.Remove("lower"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("removing from a lower layer: %v", err)
+	}
+	if err := o.Rename("lower", "moved"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("renaming in a lower layer: %v", err)
+	}
+	// Nor can what's also in a lower layer, which would show through.
+	if err := o.Rename("shared", "moved"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("renaming a file shadowing a lower one: %v", err)
+	}
+	if err := o.RemoveAll("d"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("removing a directory also in a lower layer: %v", err)
+	}
+	if data, err := fs.ReadFile(o, "shared"); err != nil || string(data) != "top" {
+		t.Errorf("shared holds %q, %v; want the top layer's still", data, err)
+	}
+	os.Mkdir(filepath.Join(dir, "own"), 0o777)
+	os.WriteFile(filepath.Join(dir, "own", "f"), nil, 0o666)
+	if err := o.RemoveAll("own"); err != nil {
+		t.Errorf("removing a directory of the top layer: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "own")); !os.IsNotExist(err) {
+		t.Errorf("own is still in the top layer: %v", err)
+	}
+
+	// Directories only in a lower layer are made in the top one to write
+	// into them.
+	u, err := o.Create("low/er/new")
+	if err != nil {
+		t.Fatalf("creating in a lower layer's directory: %v", err)
+	}
+	u.Close()
+	if _, err := os.Stat(filepath.Join(dir, "low", "er", "new")); err != nil {
+		t.Errorf("new isn't in the top layer: %v", err)
+	}
+	if err := o.Mkdir("d/b2"); err != nil {
+		t.Errorf("making a directory in one of both layers: %v", err)
+	}
+	if _, err := o.Create("nowhere/new"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("creating in a missing directory: %v", err)
+	}
+
+	ro := Overlay{fstest.MapFS{}, DirFS(dir)}
+	if _, err := ro.Create("new"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("creating under a read-only top layer: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package srv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitFS serves the tree of a commit in a git repository, reading objects
+// with the git command rather than from a checkout. The Sys value of its
+// file infos is the *GitCommit that last touched the file.
+type GitFS struct {
+	dir    string
+	commit string
+
+	mu      sync.Mutex
+	commits map[string]*GitCommit
+}
+
+// GitCommit describes a commit.
+type GitCommit struct {
+	Hash    string
+	Author  string
+	Time    time.Time
+	Subject string
+}
+
+// NewGitFS returns a filesystem over the tree of rev, which may be anything
+// git rev-parse understands, in the repository at dir.
+func NewGitFS(dir, rev string) (*GitFS, error) {
+	g := &GitFS{dir: dir, commits: make(map[string]*GitCommit)}
+	out, err := g.git("rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	g.commit = strings.TrimSpace(string(out))
+	return g, nil
+}
+
+// Commit returns the hash of the commit being served.
+func (g *GitFS) Commit() string {
+	return g.commit
+}
+
+func (g *GitFS) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"--literal-pathspecs", "-C", g.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New("git: " + msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+type gitEntry struct {
+	name string // full path
+	mode fs.FileMode
+	hash string
+	size int64
+}
+
+// lsTree lists the entries of tree-ish spec: a path, or the contents of a
+// directory if it ends in a slash.
+func (g *GitFS) lsTree(spec string) ([]gitEntry, error) {
+	args := []string{"ls-tree", "-z", "-l", g.commit}
+	if spec != "" {
+		args = append(args, "--", spec)
+	}
+	out, err := g.git(args...)
+	if err != nil {
+		return nil, err
+	}
+	var entries []gitEntry
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		tab := bytes.IndexByte(rec, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(string(rec[:tab]))
+		if len(fields) != 4 {
+			continue
+		}
+		e := gitEntry{name: string(rec[tab+1:]), hash: fields[2]}
+		switch fields[0] {
+		case "040000":
+			e.mode = fs.ModeDir | 0555
+		case "100755":
+			e.mode = 0555
+		case "120000":
+			e.mode = fs.ModeSymlink | 0777
+		case "160000":
+			// A submodule, whose tree isn't in this repository.
+			e.mode = fs.ModeIrregular
+		default:
+			e.mode = 0444
+		}
+		e.size, _ = strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (g *GitFS) stat(op, name string) (*gitEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &gitEntry{name: ".", mode: fs.ModeDir | 0555}, nil
+	}
+	entries, err := g.lsTree(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return &e, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// lastCommit returns the last commit to touch name, or nil if git can't
+// tell.
+func (g *GitFS) lastCommit(name string) *GitCommit {
+	g.mu.Lock()
+	c, ok := g.commits[name]
+	g.mu.Unlock()
+	if ok {
+		return c
+	}
+
+	args := []string{"log", "-1", "--format=%H%x00%an%x00%at%x00%s", g.commit}
+	if name != "." {
+		args = append(args, "--", name)
+	}
+	if out, err := g.git(args...); err == nil {
+		f := strings.SplitN(strings.TrimSuffix(string(out), "\n"), "\x00", 4)
+		if len(f) == 4 {
+			sec, _ := strconv.ParseInt(f[2], 10, 64)
+			c = &GitCommit{Hash: f[0], Author: f[1], Time: time.Unix(sec, 0), Subject: f[3]}
+		}
+	}
+	g.mu.Lock()
+	g.commits[name] = c
+	g.mu.Unlock()
+	return c
+}
+
+// Annotate summarizes the last commit to touch name, for listings.
+func (g *GitFS) Annotate(name string) string {
+	c := g.lastCommit(name)
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.8s %s (%s)", c.Hash, c.Subject, c.Author)
+}
+
+func (g *GitFS) info(e *gitEntry) *gitFileInfo {
+	return &gitFileInfo{g: g, gitEntry: *e}
+}
+
+func (g *GitFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := g.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return g.info(e), nil
+}
+
+// Lstat is Stat, as GitFS doesn't follow symlinks.
+func (g *GitFS) Lstat(name string) (fs.FileInfo, error) {
+	return g.Stat(name)
+}
+
+func (g *GitFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := g.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	spec := ""
+	if name != "." {
+		spec = name + "/"
+	}
+	children, err := g.lsTree(spec)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, len(children))
+	for i := range children {
+		entries[i] = fs.FileInfoToDirEntry(g.info(&children[i]))
+	}
+	return entries, nil
+}
+
+func (g *GitFS) Open(name string) (fs.File, error) {
+	e, err := g.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	fi := g.info(e)
+	switch {
+	case e.mode.IsDir():
+		entries, err := g.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirFile{fi: fi, entries: entries}, nil
+	case !e.mode.IsRegular():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := g.git("cat-file", "blob", e.hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &sectionFile{io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), fi}, nil
+}
+
+type gitFileInfo struct {
+	g *GitFS
+	gitEntry
+}
+
+func (fi *gitFileInfo) Name() string      { return path.Base(fi.name) }
+func (fi *gitFileInfo) Size() int64       { return fi.size }
+func (fi *gitFileInfo) Mode() fs.FileMode { return fi.mode }
+func (fi *gitFileInfo) IsDir() bool       { return fi.mode.IsDir() }
+
+// ModTime is the time of the last commit to touch the file.
+func (fi *gitFileInfo) ModTime() time.Time {
+	if c := fi.g.lastCommit(fi.name); c != nil {
+		return c.Time
+	}
+	return time.Time{}
+}
+
+func (fi *gitFileInfo) Sys() interface{} {
+	if c := fi.g.lastCommit(fi.name); c != nil {
+		return c
+	}
+	return nil
+}
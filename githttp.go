@@ -0,0 +1,180 @@
+package srv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// Git's HTTP transports, for bare repositories (or .git directories) in the
+// served tree. The dumb protocol only needs the repository's files, plus
+// info/refs and objects/info/packs, which are generated if
+// git update-server-info hasn't been run. The smart protocol runs
+// git upload-pack, and so needs the repository on local disk.
+
+// isGitDir reports whether name looks like a git directory.
+func isGitDir(fsys fs.FS, name string) bool {
+	for _, p := range []string{"HEAD", "objects", "refs"} {
+		if _, err := fs.Stat(fsys, path.Join(name, p)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// serveGit handles the requests of git's HTTP transports that need more
+// than a file, returning false for anything else.
+func (h *handler) serveGit(w http.ResponseWriter, r *http.Request) bool {
+	name := fsName(r.URL.Path)
+	var repo, endpoint string
+	for _, ep := range []string{"info/refs", "objects/info/packs", "git-upload-pack", "git-receive-pack"} {
+		if strings.HasSuffix(name, "/"+ep) {
+			repo, endpoint = strings.TrimSuffix(name, "/"+ep), ep
+			break
+		}
+	}
+	if repo == "" || !isGitDir(h.fsys, repo) {
+		return false
+	}
+
+	service := r.URL.Query().Get("service")
+	switch {
+	case endpoint == "git-receive-pack" || service == "git-receive-pack":
+		h.error(w, r, "pushing isn't supported", http.StatusForbidden)
+	case endpoint == "git-upload-pack" && r.Method == http.MethodPost:
+		h.gitUploadPack(w, r, repo)
+	case endpoint == "git-upload-pack":
+		h.error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+	case r.Method != http.MethodGet:
+		return false
+	case endpoint == "info/refs" && service == "git-upload-pack":
+		h.gitAdvertiseRefs(w, r, repo)
+	case endpoint == "info/refs":
+		if _, err := fs.Stat(h.fsys, name); err == nil {
+			return false
+		}
+		h.gitInfoRefs(w, r, repo)
+	case endpoint == "objects/info/packs":
+		if _, err := fs.Stat(h.fsys, name); err == nil {
+			return false
+		}
+		h.gitInfoPacks(w, r, repo)
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *handler) gitLocalDir(w http.ResponseWriter, r *http.Request, repo string) (string, bool) {
+	lfs, ok := h.fsys.(LocalFS)
+	if ok {
+		if dir, err := lfs.LocalPath(repo); err == nil {
+			return dir, true
+		}
+	}
+	h.error(w, r, "repository isn't on local disk", http.StatusNotImplemented)
+	return "", false
+}
+
+// gitCmd runs git upload-pack in dir, passing on the protocol version the
+// client asked for.
+func gitCmd(r *http.Request, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(r.Context(), "git", append([]string{"upload-pack", "--stateless-rpc"}, args...)...)
+	cmd.Args = append(cmd.Args, dir)
+	cmd.Env = os.Environ()
+	if p := r.Header.Get("Git-Protocol"); p != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+p)
+	}
+	return cmd
+}
+
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func (h *handler) gitAdvertiseRefs(w http.ResponseWriter, r *http.Request, repo string) {
+	dir, ok := h.gitLocalDir(w, r, repo)
+	if !ok {
+		return
+	}
+	out, err := gitCmd(r, dir, "--advertise-refs").Output()
+	if err != nil {
+		h.error(w, r, fmt.Sprintf("git upload-pack failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !strings.Contains(r.Header.Get("Git-Protocol"), "version=2") {
+		io.WriteString(w, pktLine("# service=git-upload-pack\n")+"0000")
+	}
+	w.Write(out)
+}
+
+func (h *handler) gitUploadPack(w http.ResponseWriter, r *http.Request, repo string) {
+	dir, ok := h.gitLocalDir(w, r, repo)
+	if !ok {
+		return
+	}
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			h.error(w, r, fmt.Sprintf("failed to decompress request: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	cmd := gitCmd(r, dir)
+	cmd.Stdin = body
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil && h.opts.Log != nil {
+		h.opts.Log.Printf("\tgit upload-pack in %s failed: %s", dir, err)
+	}
+}
+
+// gitInfoRefs generates the ref list of the dumb protocol.
+func (h *handler) gitInfoRefs(w http.ResponseWriter, r *http.Request, repo string) {
+	dir, ok := h.gitLocalDir(w, r, repo)
+	if !ok {
+		return
+	}
+	out, err := exec.CommandContext(r.Context(), "git", "-C", dir, "show-ref", "--dereference").Output()
+	// show-ref exits with 1 if there are no refs at all.
+	if ee, ok := err.(*exec.ExitError); err != nil && !(ok && ee.ExitCode() == 1 && len(out) == 0) {
+		h.error(w, r, fmt.Sprintf("git show-ref failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	// show-ref separates hash and ref by a space, info/refs by a tab.
+	w.Write(bytes.ReplaceAll(out, []byte(" "), []byte("\t")))
+}
+
+// gitInfoPacks generates the pack list of the dumb protocol.
+func (h *handler) gitInfoPacks(w http.ResponseWriter, r *http.Request, repo string) {
+	entries, err := fs.ReadDir(h.fsys, path.Join(repo, "objects/pack"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		h.error(w, r, fmt.Sprintf("failed to list packs: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".pack") {
+			fmt.Fprintf(w, "P %s\n", e.Name())
+		}
+	}
+	io.WriteString(w, "\n")
+}
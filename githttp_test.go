@@ -0,0 +1,35 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGitErrorPages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"repo.git/HEAD":               {Data: []byte("ref: refs/heads/main\n")},
+		"repo.git/objects/info/.keep": {},
+		"repo.git/refs/heads/.keep":   {},
+		"403.html":                    {Data: []byte("forbidden page")},
+		"501.html":                    {Data: []byte("not implemented page")},
+	}
+	h := New(fsys, Options{GitHTTP: true, ErrorPages: map[int]string{403: "/403.html", 501: "/501.html"}})
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/repo.git/info/refs?service=git-receive-pack", 403, "forbidden page"},
+		// MapFS isn't on local disk, so the smart protocol can't be served.
+		{"/repo.git/info/refs?service=git-upload-pack", 501, "not implemented page"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s: %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
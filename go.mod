@@ -1,5 +1,5 @@
 module srv
 
-go 1.15
+go 1.17
 
 require goftp.io/server/v2 v2.0.1
@@ -0,0 +1,105 @@
+package srv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderRule sets response headers for requests whose path matches Path, a
+// pattern as in Rule.From. Every matching rule applies in turn, replacing
+// headers set by earlier ones, and a header with a single empty value is
+// removed.
+type HeaderRule struct {
+	Path   string
+	Header http.Header
+
+	re *regexp.Regexp
+}
+
+// DefaultHeaders are the headers srv sends unless told otherwise, which
+// stop anything from being cached.
+var DefaultHeaders = []HeaderRule{{
+	Path:   "/*",
+	Header: http.Header{"Cache-Control": {"no-store"}},
+}}
+
+// ParseHeaders reads header rules in the syntax of a Netlify-style _headers
+// file: a path pattern on a line of its own, followed by indented
+// "Name: value" lines. # starts a comment.
+//
+//	/shared/*
+//	  Cross-Origin-Opener-Policy: same-origin
+//	  Cross-Origin-Embedder-Policy: require-corp
+func ParseHeaders(r io.Reader) ([]HeaderRule, error) {
+	var rules []HeaderRule
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == line {
+			rule := HeaderRule{Path: trimmed, Header: make(http.Header)}
+			if err := rule.check(); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			rules = append(rules, rule)
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("line %d: header before any path", n)
+		}
+		i := strings.IndexByte(trimmed, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected Name: value", n)
+		}
+		name, value := strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])
+		rules[len(rules)-1].Header.Add(name, value)
+	}
+	return rules, sc.Err()
+}
+
+// check validates and compiles a header rule.
+func (rule *HeaderRule) check() error {
+	if !strings.HasPrefix(rule.Path, "/") && !strings.HasPrefix(rule.Path, "^") {
+		return errors.New("path must start with / or ^")
+	}
+	var err error
+	rule.re, _, err = compilePattern(rule.Path)
+	return err
+}
+
+// setHeaders sets the headers of the rules matching r.
+func (h *handler) setHeaders(w http.ResponseWriter, r *http.Request) error {
+	rules := h.headers
+	if h.opts.HeadersFile != "" {
+		v, err := h.headersFile.load(h.fsys, fsName(h.opts.HeadersFile), func(r io.Reader) (interface{}, error) {
+			return ParseHeaders(r)
+		})
+		if err != nil {
+			return err
+		}
+		fileRules, _ := v.([]HeaderRule)
+		rules = append(rules[:len(rules):len(rules)], fileRules...)
+	}
+
+	for _, rule := range rules {
+		if !rule.re.MatchString(r.URL.Path) {
+			continue
+		}
+		for name, values := range rule.Header {
+			if len(values) == 1 && values[0] == "" {
+				w.Header().Del(name)
+			} else {
+				w.Header()[name] = values
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseHeaders(t *testing.T) {
+	rules, err := ParseHeaders(strings.NewReader(`
+# comment
+/shared/*
+  Cross-Origin-Opener-Policy: same-origin
+  Link: </a.css>; rel=preload
+  Link: </b.js>; rel=preload
+
+^/v\d+/
+	x-version:  yes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HeaderRule{
+		{Path: "/shared/*", Header: http.Header{
+			"Cross-Origin-Opener-Policy": {"same-origin"},
+			"Link":                       {"</a.css>; rel=preload", "</b.js>; rel=preload"},
+		}},
+		{Path: `^/v\d+/`, Header: http.Header{"X-Version": {"yes"}}},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i, rule := range rules {
+		if rule.Path != want[i].Path || !reflect.DeepEqual(rule.Header, want[i].Header) {
+			t.Errorf("rule %d = %s %v, want %s %v", i, rule.Path, rule.Header, want[i].Path, want[i].Header)
+		}
+	}
+}
+
+func TestParseHeadersErrors(t *testing.T) {
+	for _, in := range []string{
+		"  X-Before: path",
+		"/a\n  no colon",
+		"/a\n  : empty name",
+		"relative/*",
+		"^/(",
+	} {
+		if _, err := ParseHeaders(strings.NewReader(in)); err == nil {
+			t.Errorf("ParseHeaders(%q) succeeded", in)
+		}
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	fsys := fstest.MapFS{
+		"_headers":       {Data: []byte("/app/*\n  Cache-Control: max-age=60\n  X-From-File: yes\n")},
+		"app/index.html": {Data: []byte("app")},
+		"app/fresh.html": {Data: []byte("fresh")},
+		"other.html":     {Data: []byte("other")},
+	}
+	h := New(fsys, Options{
+		Headers: append(DefaultHeaders, HeaderRule{
+			Path:   "/app/fresh.html",
+			Header: http.Header{"X-Fresh": {"yes"}},
+		}),
+		HeadersFile: "_headers",
+	})
+	tests := []struct {
+		path   string
+		header http.Header
+	}{
+		{"/other.html", http.Header{"Cache-Control": {"no-store"}, "X-From-File": nil, "X-Fresh": nil}},
+		// The file's rules come after Options.Headers, and replace them.
+		{"/app/fresh.html", http.Header{"Cache-Control": {"max-age=60"}, "X-From-File": {"yes"}, "X-Fresh": {"yes"}}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		for name, want := range tt.header {
+			if got := w.Header()[name]; !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s %q, want %q", tt.path, name, got, want)
+			}
+		}
+	}
+
+	// An empty value removes a header.
+	h = New(fsys, Options{Headers: append(DefaultHeaders, HeaderRule{
+		Path:   "/other.html",
+		Header: http.Header{"Cache-Control": {""}},
+	})})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other.html", nil))
+	if got, ok := w.Header()["Cache-Control"]; ok {
+		t.Errorf("Cache-Control %q, want none", got)
+	}
+}
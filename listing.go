@@ -0,0 +1,240 @@
+package srv
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+func FileSize(bytes int64) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	} else if bytes < 1024*1024 {
+		return fmt.Sprintf("%.2f KB", float64(bytes)/1024)
+	} else if bytes < 1024*1024*1024 {
+		return fmt.Sprintf("%.2f MB", float64(bytes)/(1024*1024))
+	} else {
+		return fmt.Sprintf("%.2f GB", float64(bytes)/(1024*1024*1024))
+	}
+}
+
+func FileCreationDate(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
+
+const listingPrelude = `<head>
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<link rel="icon" href="data:,">
+<style>
+
+* {
+     font-family: monospace;
+}
+ table {
+     width: 100%;
+}
+ table {
+     border-spacing: 0;
+     border-collapse: collapse;
+}
+ td {
+     padding:0px;
+     overflow: hidden;
+     text-overflow: ellipsis;
+     white-space: nowrap;
+}
+ body {
+     background-color: black;
+     color: white;
+}
+ a:hover {
+     color: #eeb9da
+}
+ a {
+     color: #ff3d98
+}
+
+</style>
+</head>
+<table cellspacing="0">
+`
+
+// listingWriteScript acts on the buttons of a writable listing, asking
+// before deleting or replacing anything.
+const listingWriteScript = `<p><button data-op="mkdir">new folder</button></p>
+<script>
+(function () {
+	function path(name) {
+		return name.split("/").map(encodeURIComponent).join("/");
+	}
+	function done(resp) {
+		if (resp.ok) {
+			location.reload();
+			return;
+		}
+		return resp.text().then(function (text) {
+			alert(text);
+		});
+	}
+	function move(from, to, overwrite) {
+		return fetch(from, {
+			method: "MOVE",
+			headers: {Destination: new URL(to, location.href).href, Overwrite: overwrite ? "T" : "F"}
+		}).then(function (resp) {
+			if (resp.status === 412 && confirm(decodeURIComponent(to) + " exists. Replace it?")) {
+				return move(from, to, true);
+			}
+			return done(resp);
+		});
+	}
+	document.addEventListener("click", function (e) {
+		var b = e.target.closest("button[data-op]");
+		if (!b) {
+			return;
+		}
+		var name = b.dataset.name, dir = b.hasAttribute("data-dir");
+		var slash = dir ? "/" : "";
+		switch (b.dataset.op) {
+		case "delete":
+			if (confirm(dir ? "Delete " + name + "/ and everything in it?" : "Delete " + name + "?")) {
+				fetch(path(name) + slash + (dir ? "?recursive=1" : ""), {method: "DELETE"}).then(done);
+			}
+			break;
+		case "rename":
+			var to = prompt("Rename or move " + name + slash + " to:", name);
+			if (to && to !== name) {
+				move(path(name) + slash, path(to.replace(/\/+$/, "")) + slash, false);
+			}
+			break;
+		case "mkdir":
+			var dirName = prompt("New folder:");
+			if (dirName) {
+				fetch(path(dirName) + "/", {method: "MKCOL"}).then(done);
+			}
+			break;
+		}
+	});
+})();
+</script>
+`
+
+// listingWatchScript keeps a listing up to date, by fetching it again when
+// anything in the directory changes.
+const listingWatchScript = `<script>
+(function () {
+	var events = new EventSource("?watch=1");
+	var pending;
+	function direct(name) {
+		return name && name.indexOf("/") < 0;
+	}
+	events.onmessage = function (e) {
+		var ev = JSON.parse(e.data);
+		if (ev.op !== "overflow" && !direct(ev.name) && !direct(ev.to)) {
+			return;
+		}
+		clearTimeout(pending);
+		pending = setTimeout(function () {
+			fetch(location.pathname, {cache: "no-store"}).then(function (resp) {
+				return resp.text();
+			}).then(function (text) {
+				var doc = new DOMParser().parseFromString(text, "text/html");
+				document.querySelector("tbody").replaceWith(doc.querySelector("tbody"));
+			});
+		}, 200);
+	};
+})();
+</script>
+`
+
+// listingOptions tailors a directory listing.
+type listingOptions struct {
+	// browseArchives links archives as directories.
+	browseArchives bool
+	// annotate, if set, returns a note to show for a file.
+	annotate func(fn string) string
+	// watch makes the listing update itself as the directory changes.
+	watch bool
+	// fifos links named pipes as downloads.
+	fifos bool
+	// write adds buttons to delete files and create directories, and
+	// rename, to rename them.
+	write, rename bool
+	// writable, if set, reports whether a file can be changed.
+	writable func(fn string) bool
+}
+
+func renderListing(w http.ResponseWriter, r *http.Request, files []fs.DirEntry, opts listingOptions) error {
+	io.WriteString(w, listingPrelude)
+	io.WriteString(w, "<thead>\n    <tr><th>Name</th><th>Size</th><th>Date</th>")
+	if opts.annotate != nil {
+		io.WriteString(w, "<th>Note</th>")
+	}
+	if opts.write {
+		io.WriteString(w, "<th></th>")
+	}
+	io.WriteString(w, "</tr>\n</thead>\n<tbody>")
+
+	sort.Slice(files, func(i, j int) bool {
+		return strings.ToLower(files[i].Name()) < strings.ToLower(files[j].Name())
+	})
+
+	var fn, fnEscaped, fnHTML string
+	for _, de := range files {
+		fn = de.Name()
+		// Relative, so a name like javascript:x can't be taken for a scheme.
+		fnEscaped = "./" + url.PathEscape(fn)
+		fnHTML = html.EscapeString(fn)
+		switch m := de.Type(); {
+		case m&fs.ModeDir != 0:
+			fmt.Fprintf(w, "<tr><td><a href=\"%s/\">%s/</a></td><td></td><td></td>", fnEscaped, fnHTML)
+		case m&fs.ModeType == 0:
+			fi, err := de.Info()
+			if err != nil {
+				continue
+			}
+			creationDate := FileCreationDate(fi.ModTime())
+			size := FileSize(fi.Size())
+			if opts.browseArchives && IsArchive(fn) {
+				fmt.Fprintf(w, "<tr><td><a href=\"%s/\">%s/</a> (<a href=\"%s\">download</a>)</td><td>%s</td><td>%s</td>", fnEscaped, fnHTML, fnEscaped, size, creationDate)
+			} else {
+				fmt.Fprintf(w, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td>", fnEscaped, fnHTML, size, creationDate)
+			}
+		case m&fs.ModeNamedPipe != 0 && opts.fifos:
+			fmt.Fprintf(w, "<tr><td><a href=\"%s\">%s|</a></td><td></td><td></td>", fnEscaped, fnHTML)
+		default:
+			fmt.Fprintf(w, "<tr><td><p>%s</p></td><td></td><td></td>", fnHTML)
+		}
+		if opts.annotate != nil {
+			fmt.Fprintf(w, "<td>%s</td>", html.EscapeString(opts.annotate(fn)))
+		}
+		if opts.write && opts.writable != nil && !opts.writable(fn) {
+			io.WriteString(w, "<td></td>")
+		} else if opts.write {
+			dir := ""
+			if de.IsDir() {
+				dir = " data-dir"
+			}
+			io.WriteString(w, "<td>")
+			if opts.rename {
+				fmt.Fprintf(w, "<button data-op=\"rename\" data-name=\"%s\"%s>rename</button> ", fnHTML, dir)
+			}
+			fmt.Fprintf(w, "<button data-op=\"delete\" data-name=\"%s\"%s>delete</button></td>", fnHTML, dir)
+		}
+		io.WriteString(w, "</tr>")
+	}
+
+	io.WriteString(w, "</tbody></table>")
+	if opts.write {
+		io.WriteString(w, listingWriteScript)
+	}
+	if opts.watch {
+		io.WriteString(w, listingWatchScript)
+	}
+	return nil
+}
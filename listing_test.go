@@ -0,0 +1,24 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestListingHrefs(t *testing.T) {
+	h := New(fstest.MapFS{
+		"javascript:alert(1)": {},
+		"d:x/f":               {},
+	}, Options{})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+	for _, want := range []string{`href="./javascript:alert%281%29"`, `href="./d:x/"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing lacks %s:\n%s", want, body)
+		}
+	}
+}
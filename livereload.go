@@ -0,0 +1,176 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// liveReloadPath is where pages listen for changes when live reload is on.
+const liveReloadPath = "/.srv/livereload"
+
+// liveReloadScript is added to HTML pages. It reloads the page when told
+// to, and swaps in the stylesheets named by other messages.
+const liveReloadScript = `<script>
+(function () {
+	var events = new EventSource(%q);
+	events.addEventListener("reload", function () {
+		location.reload();
+	});
+	events.onmessage = function (e) {
+		var name = e.data;
+		var links = document.querySelectorAll("link[rel=stylesheet]");
+		var swapped = false;
+		for (var i = 0; i < links.length; i++) {
+			var url = new URL(links[i].href);
+			if (decodeURIComponent(url.pathname).slice(-name.length - 1) === "/" + name) {
+				url.searchParams.set("livereload", Date.now());
+				links[i].href = url.href;
+				swapped = true;
+			}
+		}
+		if (!swapped) {
+			location.reload();
+		}
+	};
+})();
+</script>
+`
+
+// rootKey is the context key of the relative URL of the handler's root,
+// as seen by the client.
+type rootKey struct{}
+
+// withRoot records the relative URL of the root for pages served for r.
+func withRoot(r *http.Request) *http.Request {
+	root := strings.Repeat("../", strings.Count(r.URL.Path, "/")-1)
+	if root == "" {
+		root = "./"
+	}
+	return r.WithContext(context.WithValue(r.Context(), rootKey{}, root))
+}
+
+// isHTML reports whether name is an HTML page.
+func isHTML(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+// serveLiveHTML serves the page in f with the live reload script added
+// before its closing body tag.
+func (h *handler) serveLiveHTML(w http.ResponseWriter, r *http.Request, f io.Reader) {
+	page, err := io.ReadAll(f)
+	if err != nil {
+		h.error(w, r, fmt.Sprintf("failed to read file: %s", err), http.StatusInternalServerError)
+		return
+	}
+	root, _ := r.Context().Value(rootKey{}).(string)
+	script := fmt.Sprintf(liveReloadScript, root+liveReloadPath[1:])
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		i = len(page)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)+len(script)))
+	w.Write(page[:i])
+	io.WriteString(w, script)
+	w.Write(page[i:])
+}
+
+// ignoreChange reports whether a change to name is noise, such as an
+// editor's swap file or an upload in progress.
+func ignoreChange(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") ||
+		strings.HasSuffix(base, ".swp") || strings.HasPrefix(base, "#")
+}
+
+// serveLiveReload streams server-sent events for changes to files, a burst
+// at a time: a reload event, or with CSS hot-swapping, messages naming the
+// stylesheets if they're all that changed.
+func (h *handler) serveLiveReload(w http.ResponseWriter, r *http.Request) {
+	wt, err := h.watch()
+	if err != nil {
+		h.error(w, r, fmt.Sprintf("failed to watch files: %s", err), http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.error(w, r, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := wt.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, ": watching\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		var changed []string
+		reload := false
+		add := func(ev Event) {
+			name := ev.Name
+			if ev.Op == "rename" {
+				// Editors often save by renaming a new file over the old.
+				name = ev.To
+			}
+			if ev.Op == "overflow" {
+				reload = true
+				return
+			}
+			if ignoreChange(name) {
+				return
+			}
+			if ev.Op == "delete" || !h.opts.LiveReloadCSS || !strings.HasSuffix(name, ".css") {
+				reload = true
+			}
+			changed = append(changed, name)
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+			flusher.Flush()
+			continue
+		case ev := <-events:
+			add(ev)
+		}
+
+		// Wait for the rest of the burst, as saving or building usually
+		// touches several files.
+		settle := time.NewTimer(100 * time.Millisecond)
+	burst:
+		for {
+			select {
+			case ev := <-events:
+				add(ev)
+			case <-settle.C:
+				break burst
+			}
+		}
+		switch {
+		case reload:
+			io.WriteString(w, "event: reload\ndata: \n\n")
+		case len(changed) > 0:
+			for _, name := range changed {
+				fmt.Fprintf(w, "data: %s\n\n", name)
+			}
+		default:
+			continue
+		}
+		flusher.Flush()
+	}
+}
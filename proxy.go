@@ -0,0 +1,130 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Proxy forwards requests under a path prefix to another server, including
+// WebSocket connections. Proxied requests bypass everything else the
+// handler does.
+type Proxy struct {
+	// Prefix is the path under which requests are forwarded, e.g. /api.
+	Prefix string
+
+	// Target is the server to forward to. If it has no path, requests keep
+	// theirs; otherwise Prefix is replaced by it, so /api to
+	// http://localhost:3000/v1/ forwards /api/users as /v1/users.
+	Target *url.URL
+
+	// Header is set on forwarded requests, with an empty value removing a
+	// header. Setting Host changes the host asked for, which is otherwise
+	// the client's.
+	Header http.Header
+
+	// ResponseHeader is set on responses, likewise.
+	ResponseHeader http.Header
+
+	// Timeout limits how long connecting to the target and waiting for its
+	// response headers may each take. Zero means no limit.
+	Timeout time.Duration
+}
+
+type proxyRoute struct {
+	prefix string
+	rp     *httputil.ReverseProxy
+}
+
+// newProxies sets up the reverse proxies, longest prefix first.
+func (h *handler) newProxies() []proxyRoute {
+	var routes []proxyRoute
+	for _, p := range h.opts.Proxies {
+		p := p
+		prefix := strings.TrimSuffix(p.Prefix, "/")
+		if !strings.HasPrefix(p.Prefix, "/") || p.Target == nil || p.Target.Host == "" {
+			panic(fmt.Sprintf("srv: invalid proxy %s to %v", p.Prefix, p.Target))
+		}
+		dialer := &net.Dialer{Timeout: p.Timeout, KeepAlive: 30 * time.Second}
+		rp := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.Header.Set("X-Forwarded-Host", req.Host)
+				if req.TLS != nil {
+					req.Header.Set("X-Forwarded-Proto", "https")
+				} else {
+					req.Header.Set("X-Forwarded-Proto", "http")
+				}
+				req.URL.Scheme, req.URL.Host = p.Target.Scheme, p.Target.Host
+				if p.Target.Path != "" {
+					rest := strings.TrimPrefix(req.URL.Path, prefix)
+					req.URL.Path = strings.TrimSuffix(p.Target.Path, "/") + rest
+					if req.URL.Path == "" {
+						req.URL.Path = "/"
+					}
+					req.URL.RawPath = ""
+				}
+				if _, ok := req.Header["User-Agent"]; !ok {
+					req.Header.Set("User-Agent", "")
+				}
+				for name, values := range p.Header {
+					switch {
+					case name == "Host":
+						req.Host = values[0]
+					case len(values) == 1 && values[0] == "":
+						req.Header.Del(name)
+					default:
+						req.Header[name] = values
+					}
+				}
+			},
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				ResponseHeaderTimeout: p.Timeout,
+				MaxIdleConnsPerHost:   16,
+				IdleConnTimeout:       90 * time.Second,
+			},
+			FlushInterval: -1,
+			ModifyResponse: func(resp *http.Response) error {
+				for name, values := range p.ResponseHeader {
+					if len(values) == 1 && values[0] == "" {
+						resp.Header.Del(name)
+					} else {
+						resp.Header[name] = values
+					}
+				}
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					h.error(w, r, fmt.Sprintf("backend timed out: %s", err), http.StatusGatewayTimeout)
+					return
+				}
+				h.error(w, r, fmt.Sprintf("failed to reach backend: %s", err), http.StatusBadGateway)
+			},
+		}
+		if h.opts.Log != nil {
+			rp.ErrorLog = h.opts.Log
+		}
+		routes = append(routes, proxyRoute{prefix: prefix, rp: rp})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+	return routes
+}
+
+// proxy forwards r if it's under a proxied prefix.
+func (h *handler) proxy(w http.ResponseWriter, r *http.Request) bool {
+	for _, route := range h.proxies {
+		if r.URL.Path == route.prefix || strings.HasPrefix(r.URL.Path, route.prefix+"/") {
+			route.rp.ServeHTTP(w, r)
+			return true
+		}
+	}
+	return false
+}
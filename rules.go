@@ -0,0 +1,272 @@
+package srv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is a redirect or rewrite, written like a line of a Netlify-style
+// _redirects file:
+//
+//	/old        /new              301
+//	/news/*     /blog/:splat      302
+//	/u/:name    /users/:name.html 200
+//	^/v(\d+)/   /versions/$1/     308
+//
+// From is matched against the whole request path. It's an exact path, or a
+// pattern where * matches anything (captured as :splat) and :name matches a
+// path element; or, if it starts with ^, a regular expression whose groups
+// To refers to as $1 or ${name}.
+//
+// A Status of 200 rewrites the request to To internally, and 3xx statuses
+// redirect the client to it. Any other status serves the file To with that
+// status. Unless Force is set, a rule is skipped if From names an existing
+// file.
+type Rule struct {
+	From   string
+	To     string
+	Status int
+	Force  bool
+
+	re    *regexp.Regexp
+	regex bool
+}
+
+var placeholder = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+// compilePattern compiles a path pattern: a regular expression if it
+// starts with ^, and otherwise a path where * matches anything, captured as
+// splat, and :name matches a path element.
+func compilePattern(pattern string) (re *regexp.Regexp, regex bool, err error) {
+	if strings.HasPrefix(pattern, "^") {
+		re, err = regexp.Compile(pattern)
+		return re, true, err
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for pattern != "" {
+		switch loc := placeholder.FindStringIndex(pattern); {
+		case strings.HasPrefix(pattern, "*"):
+			b.WriteString("(?P<splat>.*)")
+			pattern = pattern[1:]
+		case loc != nil && loc[0] == 0:
+			fmt.Fprintf(&b, "(?P<%s>[^/]+)", pattern[1:loc[1]])
+			pattern = pattern[loc[1]:]
+		default:
+			n := 1
+			if i := strings.IndexAny(pattern[1:], "*:"); i >= 0 {
+				n += i
+			} else {
+				n = len(pattern)
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[:n]))
+			pattern = pattern[n:]
+		}
+	}
+	b.WriteString("$")
+	re, err = regexp.Compile(b.String())
+	return re, false, err
+}
+
+// match returns the target of the rule for urlPath, if it matches.
+func (rule *Rule) match(urlPath string) (string, bool) {
+	m := rule.re.FindStringSubmatchIndex(urlPath)
+	if m == nil {
+		return "", false
+	}
+	if rule.regex {
+		return string(rule.re.ExpandString(nil, rule.To, urlPath, m)), true
+	}
+	values := make(map[string]string)
+	for i, name := range rule.re.SubexpNames() {
+		if name != "" && m[2*i] >= 0 {
+			values[name] = urlPath[m[2*i]:m[2*i+1]]
+		}
+	}
+	to := placeholder.ReplaceAllStringFunc(rule.To, func(p string) string {
+		if v, ok := values[p[1:]]; ok {
+			return v
+		}
+		return p
+	})
+	return to, true
+}
+
+// ParseRules reads rules in _redirects syntax: one per line, as
+// "from to [status][!]", with # starting comments. The status defaults to
+// 301, and a ! after it sets Force.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected from, to and an optional status", n)
+		}
+		rule := Rule{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently}
+		if len(fields) == 3 {
+			status := fields[2]
+			if strings.HasSuffix(status, "!") {
+				rule.Force, status = true, status[:len(status)-1]
+			}
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 200 || code > 599 {
+				return nil, fmt.Errorf("line %d: invalid status %q", n, fields[2])
+			}
+			rule.Status = code
+		}
+		if err := rule.check(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, sc.Err()
+}
+
+// check validates and compiles a rule.
+func (rule *Rule) check() error {
+	if !strings.HasPrefix(rule.From, "/") && !strings.HasPrefix(rule.From, "^") {
+		return errors.New("from must be a path or start with ^")
+	}
+	isRedirect := rule.Status >= 300 && rule.Status < 400
+	if !isRedirect && !strings.HasPrefix(rule.To, "/") {
+		return errors.New("rewrites must be to a path on this server")
+	}
+	var err error
+	rule.re, rule.regex, err = compilePattern(rule.From)
+	return err
+}
+
+// configRecheck is how long a config file is trusted not to have changed.
+// Checking takes requests to the server of an S3 root, or runs of git.
+const configRecheck = 2 * time.Second
+
+// configFile caches what's parsed from a file in the served tree,
+// parsing it again when it changes.
+type configFile struct {
+	mu      sync.Mutex
+	checked time.Time
+	loaded  bool
+	modTime time.Time
+	size    int64
+	v       interface{}
+}
+
+// load returns the parsed contents of name, or nil if it doesn't exist.
+func (c *configFile) load(fsys fs.FS, name string, parse func(io.Reader) (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked.IsZero() && time.Since(c.checked) < configRecheck {
+		return c.v, nil
+	}
+	fi, err := fs.Stat(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.v, c.loaded, c.checked = nil, false, time.Now()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.loaded || !fi.ModTime().Equal(c.modTime) || fi.Size() != c.size {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		v, err := parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		c.v, c.modTime, c.size, c.loaded = v, fi.ModTime(), fi.Size(), true
+	}
+	c.checked = time.Now()
+	return c.v, nil
+}
+
+// applyRules applies the first rule matching r. It returns the request to
+// carry on serving, rewritten if need be, or nil if the rule has already
+// been answered.
+func (h *handler) applyRules(w http.ResponseWriter, r *http.Request) *http.Request {
+	rules := h.rules
+	if h.opts.RulesFile != "" {
+		v, err := h.rulesFile.load(h.fsys, fsName(h.opts.RulesFile), func(r io.Reader) (interface{}, error) {
+			return ParseRules(r)
+		})
+		if err != nil {
+			h.error(w, r, fmt.Sprintf("failed to read rules: %s", err), http.StatusInternalServerError)
+			return nil
+		}
+		fileRules, _ := v.([]Rule)
+		rules = append(rules[:len(rules):len(rules)], fileRules...)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		to, ok := rule.match(r.URL.Path)
+		if !ok && r.URL.Path != "/" && strings.HasSuffix(r.URL.Path, "/") {
+			to, ok = rule.match(strings.TrimSuffix(r.URL.Path, "/"))
+		}
+		if !ok {
+			continue
+		}
+		if !rule.Force {
+			if _, err := lstat(h.fsys, fsName(r.URL.Path)); err == nil {
+				return r
+			}
+		}
+
+		switch {
+		case rule.Status >= 300 && rule.Status < 400:
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", to)
+			w.WriteHeader(rule.Status)
+			return nil
+		case rule.Status == http.StatusOK:
+			u, err := url.Parse(to)
+			if err != nil {
+				h.error(w, r, fmt.Sprintf("invalid rewrite target: %s", err), http.StatusInternalServerError)
+				return nil
+			}
+			if u.RawQuery == "" {
+				u.RawQuery = r.URL.RawQuery
+			}
+			// Keep the slash of a request for a directory, lest it be
+			// redirected to have one again.
+			if strings.HasSuffix(r.URL.Path, "/") && !strings.HasSuffix(u.Path, "/") {
+				if fi, err := fs.Stat(h.fsys, fsName(u.Path)); err == nil && fi.IsDir() {
+					u.Path += "/"
+				}
+			}
+			rewritten := new(http.Request)
+			*rewritten = *r
+			rewritten.URL = u
+			return rewritten
+		default:
+			if !h.servePage(w, to, rule.Status) {
+				h.error(w, r, "file not found", http.StatusNotFound)
+			}
+			return nil
+		}
+	}
+	return r
+}
@@ -0,0 +1,160 @@
+package srv
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# comment
+/old     /new
+/news/*  /blog/:splat  302
+/p/:slug /posts/:slug.html 200!
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{From: "/old", To: "/new", Status: 301},
+		{From: "/news/*", To: "/blog/:splat", Status: 302},
+		{From: "/p/:slug", To: "/posts/:slug.html", Status: 200, Force: true},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i, rule := range rules {
+		w := want[i]
+		if rule.From != w.From || rule.To != w.To || rule.Status != w.Status || rule.Force != w.Force {
+			t.Errorf("rule %d = %+v, want %+v", i, rule, w)
+		}
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, in := range []string{
+		"/only-from",
+		"/a /b /c /d",
+		"/a /b 99",
+		"/a /b abc",
+		"old /new",
+		"/a https://example.com/ 200",
+		"^/( /b",
+	} {
+		if _, err := ParseRules(strings.NewReader(in)); err == nil {
+			t.Errorf("ParseRules(%q) succeeded", in)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		from, to, path, want string
+		ok                   bool
+	}{
+		{"/old", "/new", "/old", "/new", true},
+		{"/old", "/new", "/older", "", false},
+		{"/news/*", "/blog/:splat", "/news/2024/a.html", "/blog/2024/a.html", true},
+		{"/news/*", "/blog/:splat", "/news/", "/blog/", true},
+		{"/u/:name", "/users/:name.html", "/u/ann", "/users/ann.html", true},
+		{"/u/:name", "/users/:name.html", "/u/ann/x", "", false},
+		{"/a.b", "/c", "/axb", "", false},
+		{`^/v(\d+)/(.*)$`, "/docs/$2?v=$1", "/v2/intro", "/docs/intro?v=2", true},
+		{`^/v(?P<n>\d+)$`, "/version/${n}", "/v10", "/version/10", true},
+	}
+	for _, tt := range tests {
+		rule := Rule{From: tt.from, To: tt.to, Status: 301}
+		if err := rule.check(); err != nil {
+			t.Fatalf("%s: %s", tt.from, err)
+		}
+		got, ok := rule.match(tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%s matching %s = %q, %v; want %q, %v", tt.from, tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	fsys := fstest.MapFS{
+		"manual/index.html": {Data: []byte("manual")},
+		"posts/foo.html":    {Data: []byte("foo")},
+		"kept.html":         {Data: []byte("kept")},
+		"gone.html":         {Data: []byte("gone")},
+	}
+	h := New(fsys, Options{
+		StripHTML: true,
+		CleanURLs: true,
+		Rules: []Rule{
+			{From: "/docs", To: "/manual", Status: 200},
+			{From: "/p/:slug", To: "/posts/:slug.html", Status: 200},
+			{From: "/old/*", To: "/new/:splat", Status: 302},
+			{From: "/kept.html", To: "/elsewhere", Status: 301},
+			{From: "/removed", To: "/gone.html", Status: 410},
+		},
+	})
+	tests := []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/docs", 301, "docs/", ""},
+		{"/docs/", 200, "", "manual"},
+		{"/p/foo", 200, "", "foo"},
+		{"/old/a?x=1", 302, "/new/a?x=1", ""},
+		{"/kept.html", 301, "./kept", ""},
+		{"/removed", 410, "", "gone"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if loc := w.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: Location %q, want %q", tt.path, loc, tt.location)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
+
+// statCounter counts the Stat and Lstat calls made on a filesystem.
+type statCounter struct {
+	fstest.MapFS
+	stats int
+}
+
+func (c *statCounter) Stat(name string) (fs.FileInfo, error) {
+	c.stats++
+	return c.MapFS.Stat(name)
+}
+
+func (c *statCounter) Lstat(name string) (fs.FileInfo, error) {
+	c.stats++
+	return c.MapFS.Stat(name)
+}
+
+func TestRulesFileCached(t *testing.T) {
+	fsys := &statCounter{MapFS: fstest.MapFS{
+		"_redirects": {Data: []byte("/old /new 302\n")},
+	}}
+	h := New(fsys, Options{RulesFile: "_redirects"})
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/old", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("status %d, want %d", w.Code, http.StatusFound)
+		}
+	}
+	// One for the rules file, and one each time for /old, to see the rule
+	// doesn't give way to a file.
+	if fsys.stats != 11 {
+		t.Errorf("%d stats, want 11", fsys.stats)
+	}
+}
@@ -0,0 +1,1058 @@
+package srv
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3APIOptions configures an S3-compatible API returned by NewS3API.
+type S3APIOptions struct {
+	// Credentials maps access key IDs to secret keys. Requests must be
+	// signed with one of them (SigV4, in headers or a presigned URL). If
+	// empty, requests aren't authenticated.
+	Credentials map[string]string
+
+	// Write enables PutObject, DeleteObject and multipart uploads, if the
+	// filesystem is a WriteFS.
+	Write bool
+
+	// Log, if set, receives a line for every request.
+	Log *log.Logger
+}
+
+// NewS3API returns a handler serving a subset of the S3 API over fsys: each
+// top-level directory is a bucket, and the files below it its objects.
+// Buckets are addressed path-style, e.g. /bucket/some/key.
+//
+// Supported are ListBuckets, HeadBucket, GetBucketLocation, ListObjects
+// (V1 and V2), GetObject, HeadObject, PutObject, CopyObject, DeleteObject
+// and multipart uploads.
+func NewS3API(fsys fs.FS, opts S3APIOptions) http.Handler {
+	return &s3API{fsys: fsys, opts: opts, uploads: make(map[string]*multipartUpload)}
+}
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// maxClockSkew is how far a signed request's date may be from ours.
+const maxClockSkew = 15 * time.Minute
+
+type s3API struct {
+	fsys fs.FS
+	opts S3APIOptions
+
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+func s3Err(status int, code, msg string) *S3Error {
+	return &S3Error{Status: status, Code: code, Message: msg}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, e *S3Error) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: e.Code, Message: e.Message, Resource: r.URL.Path})
+}
+
+func writeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// fsS3Error translates a filesystem error, reporting a missing file with
+// notFound.
+func fsS3Error(err error, notFound string) *S3Error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return s3Err(http.StatusNotFound, notFound, err.Error())
+	case errors.Is(err, fs.ErrPermission):
+		return s3Err(http.StatusForbidden, "AccessDenied", err.Error())
+	case errors.Is(err, fs.ErrInvalid):
+		return s3Err(http.StatusBadRequest, "InvalidArgument", err.Error())
+	}
+	var se *S3Error
+	if errors.As(err, &se) {
+		return se
+	}
+	return s3Err(http.StatusInternalServerError, "InternalError", err.Error())
+}
+
+func (a *s3API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Log != nil {
+		a.opts.Log.Printf("\t%s [%s]: S3 %s %s %s", r.RemoteAddr, r.UserAgent(), r.Method, r.Proto, r.Host+r.RequestURI)
+	}
+
+	auth, e := a.authenticate(r)
+	if e != nil {
+		writeS3Error(w, r, e)
+		return
+	}
+
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+		if !a.opts.Write {
+			writeS3Error(w, r, s3Err(http.StatusForbidden, "AccessDenied", "server is read-only"))
+			return
+		}
+		if _, ok := a.fsys.(WriteFS); !ok {
+			writeS3Error(w, r, s3Err(http.StatusForbidden, "AccessDenied", "filesystem is read-only"))
+			return
+		}
+	}
+
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		e = a.listBuckets(w)
+	case bucket == "":
+		e = s3Err(http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	case key == "":
+		e = a.bucketOp(w, r, bucket, q)
+	default:
+		e = a.objectOp(w, r, auth, bucket, key, q)
+	}
+	if e != nil {
+		writeS3Error(w, r, e)
+	}
+}
+
+func (a *s3API) bucketOp(w http.ResponseWriter, r *http.Request, bucket string, q url.Values) *S3Error {
+	if e := a.checkBucket(bucket); e != nil {
+		return e
+	}
+	switch {
+	case r.Method == http.MethodHead:
+		return nil
+	case r.Method == http.MethodGet && q.Has("location"):
+		writeS3XML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Xmlns   string   `xml:"xmlns,attr"`
+		}{Xmlns: s3Namespace})
+		return nil
+	case r.Method == http.MethodGet:
+		return a.listObjects(w, bucket, q)
+	}
+	return s3Err(http.StatusNotImplemented, "NotImplemented", "bucket operation not supported")
+}
+
+func (a *s3API) objectOp(w http.ResponseWriter, r *http.Request, auth *sigV4Auth, bucket, key string, q url.Values) *S3Error {
+	if e := a.checkBucket(bucket); e != nil {
+		return e
+	}
+	name := bucket + "/" + strings.TrimSuffix(key, "/")
+	if !fs.ValidPath(name) {
+		return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid object key")
+	}
+	switch {
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		return a.getObject(w, r, name, key)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		return a.uploadPart(w, r, auth, bucket, key, q)
+	case r.Method == http.MethodPut:
+		return a.putObject(w, r, auth, name, key)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		return a.createMultipartUpload(w, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		return a.completeMultipartUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		return a.abortMultipartUpload(w, bucket, key, q.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		return a.deleteObject(w, name, key)
+	}
+	return s3Err(http.StatusNotImplemented, "NotImplemented", "object operation not supported")
+}
+
+func (a *s3API) checkBucket(bucket string) *S3Error {
+	if !fs.ValidPath(bucket) || bucket == "." {
+		return s3Err(http.StatusBadRequest, "InvalidBucketName", "invalid bucket name")
+	}
+	fi, err := lstat(a.fsys, bucket)
+	if err != nil || !fi.IsDir() {
+		return s3Err(http.StatusNotFound, "NoSuchBucket", "no such bucket: "+bucket)
+	}
+	return nil
+}
+
+func (a *s3API) listBuckets(w http.ResponseWriter) *S3Error {
+	entries, err := fs.ReadDir(a.fsys, ".")
+	if err != nil {
+		return fsS3Error(err, "NoSuchBucket")
+	}
+	type bucket struct {
+		Name         string
+		CreationDate time.Time
+	}
+	var result struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID          string
+			DisplayName string
+		}
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}
+	result.Xmlns = s3Namespace
+	result.Owner.ID, result.Owner.DisplayName = "srv", "srv"
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b := bucket{Name: e.Name()}
+		if fi, err := e.Info(); err == nil {
+			b.CreationDate = fi.ModTime().UTC()
+		}
+		result.Buckets = append(result.Buckets, b)
+	}
+	writeS3XML(w, &result)
+	return nil
+}
+
+// s3Object is an entry of an object listing: an object, or with a
+// delimiter, a common prefix.
+type s3Object struct {
+	key      string
+	isPrefix bool
+	fi       fs.FileInfo
+}
+
+// objects lists the objects of a bucket whose keys start with prefix, in
+// key order. With a "/" delimiter only the directory the prefix points
+// into is read.
+func (a *s3API) objects(bucket, prefix, delimiter string) ([]s3Object, error) {
+	dir := path.Dir(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		dir = path.Clean(prefix)
+	}
+	root := path.Join(bucket, dir)
+	if fi, err := fs.Stat(a.fsys, root); err != nil || !fi.IsDir() {
+		return nil, nil
+	}
+	keyOf := func(name string) string {
+		return strings.TrimPrefix(strings.TrimPrefix(name, bucket), "/")
+	}
+
+	var objects []s3Object
+	if delimiter == "/" {
+		entries, err := fs.ReadDir(a.fsys, root)
+		if err != nil {
+			return nil, err
+		}
+		if o, ok := a.folder(bucket, root, entries); ok && strings.HasPrefix(o.key, prefix) {
+			objects = append(objects, o)
+		}
+		for _, e := range entries {
+			key := keyOf(path.Join(root, e.Name()))
+			if !strings.HasPrefix(key, prefix) || isTempUpload(e.Name()) {
+				continue
+			}
+			if e.IsDir() {
+				objects = append(objects, s3Object{key: key + "/", isPrefix: true})
+			} else if e.Type().IsRegular() {
+				fi, err := e.Info()
+				if err != nil {
+					continue
+				}
+				objects = append(objects, s3Object{key: key, fi: fi})
+			}
+		}
+	} else {
+		seen := make(map[string]bool)
+		err := fs.WalkDir(a.fsys, root, func(name string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if e.IsDir() {
+				if entries, err := fs.ReadDir(a.fsys, name); err == nil {
+					if o, ok := a.folder(bucket, name, entries); ok && strings.HasPrefix(o.key, prefix) {
+						objects = append(objects, o)
+					}
+				}
+				return nil
+			}
+			if !e.Type().IsRegular() || isTempUpload(e.Name()) {
+				return nil
+			}
+			key := keyOf(name)
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					p := key[:len(prefix)+i+len(delimiter)]
+					if !seen[p] {
+						seen[p] = true
+						objects = append(objects, s3Object{key: p, isPrefix: true})
+					}
+					return nil
+				}
+			}
+			fi, err := e.Info()
+			if err != nil {
+				return nil
+			}
+			objects = append(objects, s3Object{key: key, fi: fi})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].key < objects[j].key })
+	return objects, nil
+}
+
+// folder returns the "dir/" object standing for dir, a directory with
+// entries, if it's empty: what S3 clients make to create a directory.
+func (a *s3API) folder(bucket, dir string, entries []fs.DirEntry) (s3Object, bool) {
+	if dir == bucket {
+		return s3Object{}, false
+	}
+	for _, e := range entries {
+		if !isTempUpload(e.Name()) {
+			return s3Object{}, false
+		}
+	}
+	fi, err := fs.Stat(a.fsys, dir)
+	if err != nil {
+		return s3Object{}, false
+	}
+	return s3Object{key: strings.TrimPrefix(dir, bucket+"/") + "/", fi: folderInfo{fi}}, true
+}
+
+// folderInfo describes a directory as an empty object.
+type folderInfo struct{ fs.FileInfo }
+
+func (folderInfo) Size() int64 { return 0 }
+
+func isTempUpload(name string) bool {
+	return strings.HasPrefix(name, ".srv-upload-")
+}
+
+// etag makes an object's ETag from its modification time and size, which,
+// unlike an MD5, don't need the whole file read to list it.
+func etag(fi fs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size())
+}
+
+func (a *s3API) listObjects(w http.ResponseWriter, bucket string, q url.Values) *S3Error {
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			b, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+			}
+			after = string(b)
+		}
+	}
+
+	objects, err := a.objects(bucket, prefix, delimiter)
+	if err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	i := sort.Search(len(objects), func(i int) bool { return objects[i].key > after })
+	objects = objects[i:]
+	truncated := len(objects) > maxKeys
+	if truncated {
+		objects = objects[:maxKeys]
+	}
+
+	type content struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		Marker                *string
+		NextMarker            string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		KeyCount              *int
+		MaxKeys               int
+		IsTruncated           bool
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}{
+		Xmlns:       s3Namespace,
+		Name:        bucket,
+		Prefix:      prefix,
+		Delimiter:   delimiter,
+		MaxKeys:     maxKeys,
+		IsTruncated: truncated,
+	}
+	for _, o := range objects {
+		if o.isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{o.key})
+			continue
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          o.key,
+			LastModified: o.fi.ModTime().UTC(),
+			ETag:         etag(o.fi),
+			Size:         o.fi.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	last := ""
+	if len(objects) > 0 {
+		last = objects[len(objects)-1].key
+	}
+	if v2 {
+		n := len(objects)
+		result.KeyCount = &n
+		result.StartAfter = q.Get("start-after")
+		result.ContinuationToken = q.Get("continuation-token")
+		if truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := q.Get("marker")
+		result.Marker = &marker
+		if truncated && delimiter != "" {
+			result.NextMarker = last
+		}
+	}
+	writeS3XML(w, &result)
+	return nil
+}
+
+func (a *s3API) getObject(w http.ResponseWriter, r *http.Request, name, key string) *S3Error {
+	fi, err := lstat(a.fsys, name)
+	if err != nil || !fi.Mode().IsRegular() || strings.HasSuffix(key, "/") {
+		return s3Err(http.StatusNotFound, "NoSuchKey", "no such key: "+key)
+	}
+	f, err := a.fsys.Open(name)
+	if err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	defer f.Close()
+	w.Header().Set("ETag", etag(fi))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, fi.ModTime(), rs)
+		return nil
+	}
+	w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	serveFile(w, r, name, fi, f)
+	return nil
+}
+
+// mkdirAll creates the directories leading to name, which S3 clients
+// expect to exist implicitly.
+func mkdirAll(wfs WriteFS, name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	if fi, err := fs.Stat(wfs, dir); err == nil {
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if err := mkdirAll(wfs, dir); err != nil {
+		return err
+	}
+	if err := wfs.Mkdir(dir); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// store writes body to name, returning the MD5 of what it wrote.
+func store(wfs WriteFS, name string, body io.Reader) (string, *S3Error) {
+	if fi, err := lstat(wfs, name); err == nil && !fi.Mode().IsRegular() {
+		return "", s3Err(http.StatusConflict, "InvalidArgument", "key is a directory")
+	}
+	if err := mkdirAll(wfs, name); err != nil {
+		return "", fsS3Error(err, "NoSuchKey")
+	}
+	u, err := wfs.Create(name)
+	if err != nil {
+		return "", fsS3Error(err, "NoSuchKey")
+	}
+	sum := md5.New()
+	if _, err := io.Copy(io.MultiWriter(u, sum), body); err != nil {
+		u.Abort()
+		var se *S3Error
+		if errors.As(err, &se) {
+			return "", se
+		}
+		return "", s3Err(http.StatusBadRequest, "IncompleteBody", err.Error())
+	}
+	if err := u.Close(); err != nil {
+		return "", fsS3Error(err, "NoSuchKey")
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func (a *s3API) putObject(w http.ResponseWriter, r *http.Request, auth *sigV4Auth, name, key string) *S3Error {
+	body, e := payload(r, auth)
+	if e != nil {
+		return e
+	}
+	if strings.HasSuffix(key, "/") {
+		// An empty "folder" object becomes a directory.
+		io.Copy(io.Discard, body)
+		if err := mkdirAll(a.fsys.(WriteFS), path.Join(name, "x")); err != nil {
+			return fsS3Error(err, "NoSuchKey")
+		}
+		return a.setETag(w, name)
+	}
+	if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+		return a.copyObject(w, name, source)
+	}
+	if _, e := store(a.fsys.(WriteFS), name, body); e != nil {
+		return e
+	}
+	return a.setETag(w, name)
+}
+
+// setETag sets the ETag of the object just written to name.
+func (a *s3API) setETag(w http.ResponseWriter, name string) *S3Error {
+	fi, err := lstat(a.fsys, name)
+	if err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	w.Header().Set("ETag", etag(fi))
+	return nil
+}
+
+// copyObject copies the object source, given as "bucket/key", to name.
+func (a *s3API) copyObject(w http.ResponseWriter, name, source string) *S3Error {
+	if i := strings.IndexByte(source, '?'); i >= 0 {
+		source = source[:i]
+	}
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+	}
+	i := strings.IndexByte(source, '/')
+	src := strings.TrimSuffix(source, "/")
+	if i < 0 || !fs.ValidPath(src) {
+		return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+	}
+	if e := a.checkBucket(source[:i]); e != nil {
+		return e
+	}
+	fi, err := lstat(a.fsys, src)
+	if err != nil || !fi.Mode().IsRegular() || src != source {
+		return s3Err(http.StatusNotFound, "NoSuchKey", "no such key: "+source[i+1:])
+	}
+	f, err := a.fsys.Open(src)
+	if err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	defer f.Close()
+	if _, e := store(a.fsys.(WriteFS), name, f); e != nil {
+		return e
+	}
+	if fi, err = lstat(a.fsys, name); err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	writeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		Xmlns        string   `xml:"xmlns,attr"`
+		LastModified time.Time
+		ETag         string
+	}{Xmlns: s3Namespace, LastModified: fi.ModTime().UTC(), ETag: etag(fi)})
+	return nil
+}
+
+func (a *s3API) deleteObject(w http.ResponseWriter, name, key string) *S3Error {
+	wfs := a.fsys.(WriteFS)
+	fi, err := lstat(wfs, name)
+	remove := err == nil && !fi.IsDir()
+	if err == nil && fi.IsDir() && strings.HasSuffix(key, "/") {
+		// A "folder" object is an empty directory.
+		entries, err := fs.ReadDir(wfs, name)
+		remove = err == nil && len(entries) == 0
+	}
+	if remove {
+		if err := wfs.Remove(name); err != nil {
+			return fsS3Error(err, "NoSuchKey")
+		}
+	}
+	// Prune directories left empty, short of the bucket itself.
+	for dir := path.Dir(name); strings.Contains(dir, "/"); dir = path.Dir(dir) {
+		if entries, err := fs.ReadDir(wfs, dir); err != nil || len(entries) > 0 || wfs.Remove(dir) != nil {
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// multipartUpload keeps the parts of an upload in a local temporary
+// directory until it's completed.
+type multipartUpload struct {
+	bucket, key string
+	dir         string
+}
+
+func (a *s3API) upload(bucket, key, id string) (*multipartUpload, *S3Error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.uploads[id]
+	if !ok || u.bucket != bucket || u.key != key {
+		return nil, s3Err(http.StatusNotFound, "NoSuchUpload", "no such upload: "+id)
+	}
+	return u, nil
+}
+
+func (a *s3API) createMultipartUpload(w http.ResponseWriter, bucket, key string) *S3Error {
+	if strings.HasSuffix(key, "/") {
+		return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid object key")
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return s3Err(http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	id := hex.EncodeToString(b[:])
+	dir, err := os.MkdirTemp("", "srv-s3-multipart-")
+	if err != nil {
+		return s3Err(http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	a.mu.Lock()
+	a.uploads[id] = &multipartUpload{bucket: bucket, key: key, dir: dir}
+	a.mu.Unlock()
+
+	writeS3XML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+func partFile(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d", n))
+}
+
+func (a *s3API) uploadPart(w http.ResponseWriter, r *http.Request, auth *sigV4Auth, bucket, key string, q url.Values) *S3Error {
+	u, e := a.upload(bucket, key, q.Get("uploadId"))
+	if e != nil {
+		return e
+	}
+	n, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || n < 1 || n > 10000 {
+		return s3Err(http.StatusBadRequest, "InvalidArgument", "invalid part number")
+	}
+	body, e := payload(r, auth)
+	if e != nil {
+		return e
+	}
+	sum, e := store(DirFS(u.dir), path.Base(partFile(u.dir, n)), body)
+	if e != nil {
+		return e
+	}
+	w.Header().Set("ETag", "\""+sum+"\"")
+	return nil
+}
+
+func (a *s3API) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) *S3Error {
+	u, e := a.upload(bucket, key, id)
+	if e != nil {
+		return e
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil || len(req.Parts) == 0 {
+		return s3Err(http.StatusBadRequest, "MalformedXML", "invalid CompleteMultipartUpload request")
+	}
+
+	// Check the parts before writing anything.
+	last := 0
+	for _, p := range req.Parts {
+		if p.PartNumber <= last {
+			return s3Err(http.StatusBadRequest, "InvalidPartOrder", "parts must be in ascending order")
+		}
+		last = p.PartNumber
+		sum, err := fileMD5(partFile(u.dir, p.PartNumber))
+		if err != nil || strings.Trim(p.ETag, "\"") != hex.EncodeToString(sum) {
+			return s3Err(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d wasn't uploaded", p.PartNumber))
+		}
+	}
+
+	wfs := a.fsys.(WriteFS)
+	name := bucket + "/" + key
+	if err := mkdirAll(wfs, name); err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	dst, err := wfs.Create(name)
+	if err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	for _, p := range req.Parts {
+		f, err := os.Open(partFile(u.dir, p.PartNumber))
+		if err == nil {
+			_, err = io.Copy(dst, f)
+			f.Close()
+		}
+		if err != nil {
+			dst.Abort()
+			return s3Err(http.StatusInternalServerError, "InternalError", err.Error())
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+	a.abort(id)
+	fi, err := lstat(wfs, name)
+	if err != nil {
+		return fsS3Error(err, "NoSuchKey")
+	}
+
+	writeS3XML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Xmlns: s3Namespace, Bucket: bucket, Key: key, ETag: etag(fi)})
+	return nil
+}
+
+func fileMD5(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sum := md5.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return nil, err
+	}
+	return sum.Sum(nil), nil
+}
+
+func (a *s3API) abortMultipartUpload(w http.ResponseWriter, bucket, key, id string) *S3Error {
+	if _, e := a.upload(bucket, key, id); e != nil {
+		return e
+	}
+	a.abort(id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *s3API) abort(id string) {
+	a.mu.Lock()
+	u := a.uploads[id]
+	delete(a.uploads, id)
+	a.mu.Unlock()
+	if u != nil {
+		os.RemoveAll(u.dir)
+	}
+}
+
+// sigV4Auth is what's needed of a verified request's signature to check
+// the signatures of its payload chunks.
+type sigV4Auth struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string
+}
+
+// authenticate checks the SigV4 signature of r, given in its Authorization
+// header or, for presigned URLs, its query.
+func (a *s3API) authenticate(r *http.Request) (*sigV4Auth, *S3Error) {
+	if len(a.opts.Credentials) == 0 {
+		return nil, nil
+	}
+	denied := func(msg string) (*sigV4Auth, *S3Error) {
+		return nil, s3Err(http.StatusForbidden, "AccessDenied", msg)
+	}
+
+	q := r.URL.Query()
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	presigned := false
+	if h := r.Header.Get("Authorization"); h != "" {
+		if !strings.HasPrefix(h, sigV4Algorithm+" ") {
+			return denied("only " + sigV4Algorithm + " signatures are supported")
+		}
+		for _, kv := range strings.Split(h[len(sigV4Algorithm)+1:], ",") {
+			kv = strings.TrimSpace(kv)
+			switch {
+			case strings.HasPrefix(kv, "Credential="):
+				credential = kv[len("Credential="):]
+			case strings.HasPrefix(kv, "SignedHeaders="):
+				signedHeaders = kv[len("SignedHeaders="):]
+			case strings.HasPrefix(kv, "Signature="):
+				signature = kv[len("Signature="):]
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, s3Err(http.StatusBadRequest, "InvalidRequest", "missing x-amz-content-sha256")
+		}
+	} else if q.Get("X-Amz-Algorithm") == sigV4Algorithm {
+		presigned = true
+		credential = q.Get("X-Amz-Credential")
+		signedHeaders = q.Get("X-Amz-SignedHeaders")
+		signature = q.Get("X-Amz-Signature")
+		amzDate = q.Get("X-Amz-Date")
+		payloadHash = unsignedPayload
+	} else {
+		return denied("request isn't signed")
+	}
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return denied("malformed signature")
+	}
+	secret, ok := a.opts.Credentials[parts[0]]
+	if !ok {
+		return nil, s3Err(http.StatusForbidden, "InvalidAccessKeyId", "unknown access key")
+	}
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil || t.Format("20060102") != parts[1] {
+		return denied("invalid request date")
+	}
+	now := time.Now()
+	if presigned {
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || now.After(t.Add(time.Duration(expires)*time.Second)) || t.After(now.Add(maxClockSkew)) {
+			return denied("request has expired")
+		}
+	} else if d := now.Sub(t); d > maxClockSkew || d < -maxClockSkew {
+		return nil, s3Err(http.StatusForbidden, "RequestTimeTooSkewed", "request time is too far from the server's")
+	}
+
+	// Verify against the path the client signed, before any prefix was
+	// stripped from it.
+	signed := *r
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		signed.URL = u
+	}
+	scope := strings.Join(parts[1:], "/")
+	key := sigV4Key(secret, parts[1], parts[2], parts[3])
+	canonical := canonicalRequest(&signed, strings.Split(signedHeaders, ";"), payloadHash)
+	want := hex.EncodeToString(hmacSHA256(key, sigV4StringToSign(t, scope, canonical)))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return nil, s3Err(http.StatusForbidden, "SignatureDoesNotMatch", "signature doesn't match")
+	}
+	return &sigV4Auth{key: key, amzDate: amzDate, scope: scope, signature: signature}, nil
+}
+
+// payload returns the body of r, decoded from aws-chunked encoding and
+// checked against the hashes or signatures the client sent, if any.
+func payload(r *http.Request, auth *sigV4Auth) (io.Reader, *S3Error) {
+	switch h := r.Header.Get("X-Amz-Content-Sha256"); {
+	case h == "", h == unsignedPayload:
+		return r.Body, nil
+	case h == "STREAMING-UNSIGNED-PAYLOAD-TRAILER":
+		return &awsChunkedReader{br: bufio.NewReader(r.Body)}, nil
+	case strings.HasPrefix(h, "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"):
+		cr := &awsChunkedReader{br: bufio.NewReader(r.Body)}
+		if auth != nil {
+			cr.auth, cr.prevSig = auth, auth.signature
+		}
+		return cr, nil
+	case len(h) == 64:
+		want, err := hex.DecodeString(h)
+		if err != nil {
+			break
+		}
+		return &hashReader{r: r.Body, h: sha256.New(), want: want}, nil
+	}
+	return nil, s3Err(http.StatusBadRequest, "InvalidArgument", "unsupported x-amz-content-sha256")
+}
+
+// hashReader fails at EOF if what it read doesn't hash to want.
+type hashReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+func (hr *hashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(hr.h.Sum(nil), hr.want) {
+		err = s3Err(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload doesn't match x-amz-content-sha256")
+	}
+	return n, err
+}
+
+// awsChunkedReader decodes an aws-chunked body, verifying the signature of
+// each chunk if auth is set. Trailing headers, which carry checksums, are
+// skipped.
+type awsChunkedReader struct {
+	br      *bufio.Reader
+	auth    *sigV4Auth
+	prevSig string
+
+	remaining int64
+	sig       string
+	h         hash.Hash
+	done      bool
+}
+
+func chunkedError(msg string) error {
+	return s3Err(http.StatusBadRequest, "IncompleteBody", msg)
+}
+
+func (cr *awsChunkedReader) line() (string, error) {
+	l, err := cr.br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = chunkedError("truncated aws-chunked body")
+		}
+		return "", err
+	}
+	return strings.TrimRight(l, "\r\n"), nil
+}
+
+// verify checks the signature of the chunk just read.
+func (cr *awsChunkedReader) verify() error {
+	if cr.auth == nil {
+		return nil
+	}
+	sts := "AWS4-HMAC-SHA256-PAYLOAD\n" + cr.auth.amzDate + "\n" + cr.auth.scope + "\n" +
+		cr.prevSig + "\n" + emptySHA256 + "\n" + hex.EncodeToString(cr.h.Sum(nil))
+	want := hex.EncodeToString(hmacSHA256(cr.auth.key, sts))
+	if !hmac.Equal([]byte(want), []byte(cr.sig)) {
+		return s3Err(http.StatusForbidden, "SignatureDoesNotMatch", "chunk signature doesn't match")
+	}
+	cr.prevSig = cr.sig
+	return nil
+}
+
+// next reads the header of the next chunk.
+func (cr *awsChunkedReader) next() error {
+	l, err := cr.line()
+	if err != nil {
+		return err
+	}
+	size, ext := l, ""
+	if i := strings.IndexByte(l, ';'); i >= 0 {
+		size, ext = l[:i], l[i+1:]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		return chunkedError("invalid chunk size")
+	}
+	cr.sig = strings.TrimPrefix(ext, "chunk-signature=")
+	if cr.auth != nil && cr.sig == "" {
+		return chunkedError("unsigned chunk")
+	}
+	cr.remaining, cr.h = n, sha256.New()
+	if n > 0 {
+		return nil
+	}
+
+	// The final chunk: check it, then skip any trailers.
+	if err := cr.verify(); err != nil {
+		return err
+	}
+	for {
+		l, err := cr.line()
+		if err != nil {
+			return err
+		}
+		if l == "" {
+			break
+		}
+	}
+	cr.done = true
+	return nil
+}
+
+func (cr *awsChunkedReader) Read(p []byte) (int, error) {
+	for cr.remaining == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if cr.h != nil {
+			// End of a data chunk.
+			if l, err := cr.line(); err != nil || l != "" {
+				return 0, chunkedError("malformed chunk")
+			}
+			if err := cr.verify(); err != nil {
+				return 0, err
+			}
+		}
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.br.Read(p)
+	cr.h.Write(p[:n])
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		err = chunkedError("truncated aws-chunked body")
+	}
+	return n, err
+}
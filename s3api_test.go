@@ -0,0 +1,169 @@
+package srv
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newS3Test serves the S3 API, writable and open to anyone, over a
+// temporary directory holding an empty bucket.
+func newS3Test(t *testing.T) (string, http.Handler) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "b"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	return dir, NewS3API(DirFS(dir), S3APIOptions{Write: true})
+}
+
+func s3Do(t *testing.T, h http.Handler, method, target string, header http.Header, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, vs := range header {
+		r.Header[k] = vs
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code/100 != 2 {
+		t.Fatalf("%s %s: %d %s", method, target, w.Code, w.Body)
+	}
+	return w
+}
+
+func TestS3CopyObject(t *testing.T) {
+	dir, h := newS3Test(t)
+	s3Do(t, h, http.MethodPut, "/b/a%20b", nil, "hello")
+	w := s3Do(t, h, http.MethodPut, "/b/dir/c", http.Header{"X-Amz-Copy-Source": {"/b/a%20b"}}, "")
+	if !strings.Contains(w.Body.String(), "<CopyObjectResult") {
+		t.Errorf("copy answered %s", w.Body)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "b/dir/c"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("copy holds %q, %v", data, err)
+	}
+
+	for _, source := range []string{"b/missing", "b/dir", "b/dir/c/", "nobucket/a", "b/../b/a b"} {
+		r := httptest.NewRequest(http.MethodPut, "/b/x", nil)
+		r.Header.Set("X-Amz-Copy-Source", source)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code/100 != 4 {
+			t.Errorf("copying %s: status %d", source, w.Code)
+		}
+	}
+}
+
+func TestS3ETags(t *testing.T) {
+	_, h := newS3Test(t)
+	put := s3Do(t, h, http.MethodPut, "/b/k", nil, "data").Header().Get("ETag")
+	if put == "" {
+		t.Fatal("PutObject returned no ETag")
+	}
+	copied := s3Do(t, h, http.MethodPut, "/b/k", http.Header{"X-Amz-Copy-Source": {"b/k"}}, "")
+	if !strings.Contains(copied.Body.String(), "<ETag>") {
+		t.Errorf("CopyObject returned no ETag: %s", copied.Body)
+	}
+	put = s3Do(t, h, http.MethodPut, "/b/k", nil, "data").Header().Get("ETag")
+	if got := s3Do(t, h, http.MethodGet, "/b/k", nil, "").Header().Get("ETag"); got != put {
+		t.Errorf("GetObject ETag %s, PutObject's %s", got, put)
+	}
+	if got := s3Do(t, h, http.MethodHead, "/b/k", nil, "").Header().Get("ETag"); got != put {
+		t.Errorf("HeadObject ETag %s, PutObject's %s", got, put)
+	}
+	list := s3Do(t, h, http.MethodGet, "/b?list-type=2", nil, "").Body.String()
+	if !strings.Contains(list, "<ETag>"+strings.ReplaceAll(put, `"`, "&#34;")+"</ETag>") {
+		t.Errorf("ListObjectsV2 doesn't have ETag %s: %s", put, list)
+	}
+}
+
+// noCopy answers CopyObject as servers without it do.
+type noCopy struct{ http.Handler }
+
+func (h noCopy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, s3Err(http.StatusNotImplemented, "NotImplemented", "CopyObject isn't supported"))
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func TestS3FSRename(t *testing.T) {
+	for _, copy := range []bool{true, false} {
+		dir, h := newS3Test(t)
+		if !copy {
+			h = noCopy{h}
+		}
+		ts := httptest.NewServer(h)
+		s := NewS3FS(S3Options{Endpoint: ts.URL, Bucket: "b"})
+		u, err := s.Create("old")
+		if err == nil {
+			io.WriteString(u, "contents")
+			err = u.Close()
+		}
+		if err == nil {
+			err = s.Rename("old", "sub/new")
+		}
+		ts.Close()
+		if err != nil {
+			t.Fatalf("copy %v: %s", copy, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "b/old")); !os.IsNotExist(err) {
+			t.Errorf("copy %v: old name still there: %v", copy, err)
+		}
+		data, err := fs.ReadFile(DirFS(dir), "b/sub/new")
+		if err != nil || string(data) != "contents" {
+			t.Errorf("copy %v: new name holds %q, %v", copy, data, err)
+		}
+	}
+}
+
+func TestS3FSMkdir(t *testing.T) {
+	dir, h := newS3Test(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	s := NewS3FS(S3Options{Endpoint: ts.URL, Bucket: "b"})
+
+	if err := s.Mkdir("d"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := s.Stat("d"); err != nil || !fi.IsDir() {
+		t.Errorf("stat of the new directory: %v, %v", fi, err)
+	}
+	if entries, err := s.ReadDir("."); err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		t.Errorf("root lists %v, %v; want the new directory", entries, err)
+	}
+	if entries, err := s.ReadDir("d"); err != nil || len(entries) != 0 {
+		t.Errorf("new directory lists %v, %v", entries, err)
+	}
+	if err := s.Mkdir("d"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("making the directory again: %v", err)
+	}
+	if err := s.Mkdir("x/y"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("making a directory in a missing one: %v", err)
+	}
+	if err := s.Mkdir("d/e"); err != nil {
+		t.Errorf("making a directory in the new one: %v", err)
+	}
+	if err := s.Remove("d/e"); err != nil {
+		t.Errorf("removing an empty directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b/d/e")); !os.IsNotExist(err) {
+		t.Errorf("removed directory is still there: %v", err)
+	}
+
+	// MKCOL answers as it does for local directories.
+	srv := New(s, Options{Write: true})
+	for _, want := range []int{http.StatusCreated, http.StatusMethodNotAllowed} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("MKCOL", "/new", nil))
+		if w.Code != want {
+			t.Errorf("MKCOL: status %d, want %d", w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package srv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Options locates a bucket on an S3-compatible server.
+type S3Options struct {
+	// Endpoint is the server's base URL, such as http://127.0.0.1:9000.
+	// Buckets are addressed path-style beneath it.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix, if set, is the key prefix served as the root.
+	Prefix string
+
+	// Requests are anonymous if AccessKey is empty.
+	AccessKey, SecretKey, SessionToken string
+
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3FS is a filesystem over the objects of an S3 bucket, treating slashes in
+// keys as directory separators. It implements WriteFS.
+type S3FS struct {
+	opts S3Options
+}
+
+// NewS3FS returns a filesystem over the bucket described by opts.
+func NewS3FS(opts S3Options) *S3FS {
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &S3FS{opts: opts}
+}
+
+func (s *S3FS) key(name string) string {
+	if name == "." {
+		return s.opts.Prefix
+	}
+	if s.opts.Prefix == "" {
+		return name
+	}
+	return s.opts.Prefix + "/" + name
+}
+
+// S3Error is an error response from an S3 server.
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return "s3: " + http.StatusText(e.Status)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+func (e *S3Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Status == http.StatusNotFound
+	case fs.ErrPermission:
+		return e.Status == http.StatusForbidden
+	}
+	return false
+}
+
+// do sends a request for key with the given query, returning the response
+// if it succeeded.
+func (s *S3FS) do(method, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := s.opts.Endpoint + "/" + awsEscape(s.opts.Bucket, true)
+	if key != "" {
+		u += "/" + awsEscape(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if s.opts.AccessKey != "" {
+		if payloadHash == "" {
+			payloadHash = emptySHA256
+		}
+		signV4(req, s.opts.AccessKey, s.opts.SecretKey, s.opts.SessionToken, s.opts.Region, "s3", payloadHash, time.Now())
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		e := &S3Error{Status: resp.StatusCode}
+		if method != http.MethodHead {
+			xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(e)
+		}
+		return nil, e
+	}
+	return resp, nil
+}
+
+func (s *S3FS) Open(name string) (fs.File, error) {
+	fi, err := s.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirFile{fi: fi, entries: entries}, nil
+	}
+	return &s3File{s: s, key: s.key(name), fi: fi}, nil
+}
+
+func (s *S3FS) Stat(name string) (fs.FileInfo, error) {
+	return s.stat("stat", name)
+}
+
+// Lstat is Stat, as S3 has no symlinks.
+func (s *S3FS) Lstat(name string) (fs.FileInfo, error) {
+	return s.stat("lstat", name)
+}
+
+func (s *S3FS) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return implicitDir("."), nil
+	}
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, nil, 0, "")
+	if err == nil {
+		resp.Body.Close()
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &s3FileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	// Directories exist only as the common prefix of some keys.
+	page, err := s.list(s.key(name)+"/", "", 1)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return implicitDir(path.Base(name)), nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list fetches one page of the keys directly below prefix.
+func (s *S3FS) list(prefix, token string, max int) (*listBucketResult, error) {
+	q := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+		"delimiter": {"/"},
+	}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	if max > 0 {
+		q.Set("max-keys", strconv.Itoa(max))
+	}
+	resp, err := s.do(http.MethodGet, "", q, nil, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	page := new(listBucketResult)
+	if err := xml.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// ReadDir lists a directory, following the listing across as many pages as
+// the server splits it into.
+func (s *S3FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	prefix := s.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	var entries []fs.DirEntry
+	token := ""
+	for {
+		page, err := s.list(prefix, token, 0)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		for _, p := range page.CommonPrefixes {
+			base := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			if base != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(implicitDir(base)))
+			}
+		}
+		for _, c := range page.Contents {
+			// Skip the empty objects some tools use as directory markers.
+			if base := strings.TrimPrefix(c.Key, prefix); base != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{name: base, size: c.Size, modTime: c.LastModified}))
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	if len(entries) == 0 && name != "." {
+		if _, err := s.stat("readdir", name); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Create spools the upload to a temporary file, as S3 needs its length and
+// hash up front, and puts it when closed.
+func (s *S3FS) Create(name string) (Upload, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := os.CreateTemp("", "srv-s3-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Upload{s: s, key: s.key(name), f: f, h: sha256.New()}, nil
+}
+
+// Mkdir puts an empty "name/" marker object, as directories otherwise
+// exist only while keys under them do.
+func (s *S3FS) Mkdir(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, err := s.stat("mkdir", name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if dir := path.Dir(name); dir != "." {
+		fi, err := s.stat("mkdir", dir)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+	}
+	resp, err := s.do(http.MethodPut, s.key(name)+"/", nil, nil, nil, 0, "")
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Remove deletes an object. Directories exist only while keys under them
+// do, so removing an empty one deletes just its "name/" marker, if any.
+func (s *S3FS) Remove(name string) error {
+	fi, err := s.stat("remove", name)
+	if err != nil {
+		return err
+	}
+	key := s.key(name)
+	if fi.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 || name == "." {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		key += "/"
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, 0, "")
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Rename copies an object to its new key and deletes the old one. S3 has
+// no way to move the many keys of a directory at once, so they can't be
+// renamed.
+func (s *S3FS) Rename(oldname, newname string) error {
+	if !fs.ValidPath(newname) || newname == "." {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+	fi, err := s.stat("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: errors.New("can't rename directories in S3")}
+	}
+	source := "/" + awsEscape(s.opts.Bucket, true) + "/" + awsEscape(s.key(oldname), false)
+	header := http.Header{"X-Amz-Copy-Source": {source}}
+	resp, err := s.do(http.MethodPut, s.key(newname), nil, header, nil, 0, "")
+	var se *S3Error
+	if errors.As(err, &se) && se.Status == http.StatusNotImplemented {
+		// Not every S3-compatible server can copy objects.
+		err = s.copy(oldname, newname)
+	} else if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	return s.Remove(oldname)
+}
+
+// copy copies an object by downloading and uploading it again.
+func (s *S3FS) copy(oldname, newname string) error {
+	f, err := s.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	u, err := s.Create(newname)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(u, f); err != nil {
+		u.Abort()
+		return err
+	}
+	return u.Close()
+}
+
+type s3Upload struct {
+	s   *S3FS
+	key string
+	f   *os.File
+	h   hash.Hash
+	n   int64
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	n, err := u.f.Write(p)
+	u.h.Write(p[:n])
+	u.n += int64(n)
+	return n, err
+}
+
+func (u *s3Upload) Close() error {
+	defer u.Abort()
+	if _, err := u.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := u.s.do(http.MethodPut, u.key, nil, nil, io.NopCloser(u.f), u.n, hex.EncodeToString(u.h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (u *s3Upload) Abort() error {
+	u.f.Close()
+	return os.Remove(u.f.Name())
+}
+
+// s3File reads an object with ranged GETs, keeping one response open for
+// sequential reads.
+type s3File struct {
+	s    *S3FS
+	key  string
+	fi   fs.FileInfo
+	off  int64
+	body io.ReadCloser
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.fi, nil }
+
+func (f *s3File) get(off, end int64) (io.ReadCloser, error) {
+	h := http.Header{"Range": {fmt.Sprintf("bytes=%d-", off)}}
+	if end >= 0 {
+		h.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	}
+	resp, err := f.s.do(http.MethodGet, f.key, nil, h, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.off >= f.fi.Size() {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.get(f.off, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.off += int64(n)
+	if err == io.EOF && f.off < f.fi.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= f.fi.Size() {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p)) - 1
+	if end >= f.fi.Size() {
+		end = f.fi.Size() - 1
+	}
+	body, err := f.get(off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.fi.Size()
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != f.off && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return false }
+func (fi *s3FileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,123 @@
+package srv
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as used by S3.
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sigV4Key(secret, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// optionally slashes.
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(r *http.Request) string {
+	var params []string
+	for k, vs := range r.URL.Query() {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			params = append(params, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalRequest builds the canonical form of r that SigV4 signs, over
+// the given lowercase header names.
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(awsEscape(r.URL.Path, false) + "\n")
+	b.WriteString(canonicalQuery(r) + "\n")
+	for _, h := range signedHeaders {
+		var v string
+		if h == "host" {
+			v = r.Host
+			if v == "" {
+				v = r.URL.Host
+			}
+		} else {
+			v = strings.Join(r.Header.Values(h), ",")
+		}
+		b.WriteString(h + ":" + strings.Join(strings.Fields(v), " ") + "\n")
+	}
+	b.WriteString("\n" + strings.Join(signedHeaders, ";") + "\n")
+	b.WriteString(payloadHash)
+	return b.String()
+}
+
+func sigV4StringToSign(t time.Time, scope, canonical string) string {
+	return sigV4Algorithm + "\n" + t.UTC().Format(sigV4TimeFormat) + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+}
+
+// signV4 adds SigV4 authentication headers to r, an outgoing request whose
+// body hashes to payloadHash.
+func signV4(r *http.Request, accessKey, secretKey, sessionToken, region, service, payloadHash string, t time.Time) {
+	t = t.UTC()
+	r.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	signed := []string{"host"}
+	for k := range r.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-type" {
+			signed = append(signed, k)
+		}
+	}
+	sort.Strings(signed)
+
+	date := t.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	sts := sigV4StringToSign(t, scope, canonicalRequest(r, signed, payloadHash))
+	sig := hex.EncodeToString(hmacSHA256(sigV4Key(secretKey, date, region, service), sts))
+	r.Header.Set("Authorization", sigV4Algorithm+" Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+sig)
+}
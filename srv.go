@@ -0,0 +1,446 @@
+// Package srv implements srv's file browser as an http.Handler over any
+// fs.FS: directory listings, file serving and the policy around them.
+package srv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a handler returned by New.
+type Options struct {
+	// Log, if set, receives a line for every request.
+	Log *log.Logger
+
+	// BrowseArchives lets clients list and fetch the contents of zip and
+	// tar files by requesting them as directories, e.g. /logs.zip/app.log.
+	BrowseArchives bool
+
+	// Write enables uploads with PUT, deleting with DELETE, creating
+	// directories with MKCOL and renaming with MOVE, if the filesystem is a
+	// WriteFS (and a RenameFS, for MOVE). Listings offer them too.
+	Write bool
+
+	// CleanURLs serves /page from page.html if there's no file called page.
+	CleanURLs bool
+
+	// StripHTML redirects requests for page.html to /page, and for
+	// index.html to its directory. It's meant to be used with CleanURLs.
+	StripHTML bool
+
+	// SPA maps URL path prefixes to files, relative to the root, that are
+	// served with status 200 in place of anything missing under the
+	// prefix, as single-page apps route on the client side.
+	SPA map[string]string
+
+	// ErrorPages maps status codes to files, relative to the root, that are
+	// served in place of the plain-text error messages.
+	ErrorPages map[int]string
+
+	// GitHTTP makes git repositories in the tree cloneable over git's dumb
+	// HTTP protocol, and its smart one if they're on local disk.
+	GitHTTP bool
+
+	// Rules are redirects and rewrites applied before looking up files.
+	// New panics if one is invalid.
+	Rules []Rule
+
+	// RulesFile, if set, names a file relative to the root with further
+	// rules in _redirects syntax, applied after Rules. It's reread when
+	// it changes.
+	RulesFile string
+
+	// Headers are set on responses to requests for matching paths. Use
+	// DefaultHeaders for srv's usual caching headers.
+	Headers []HeaderRule
+
+	// HeadersFile, if set, names a file relative to the root with further
+	// header rules in _headers syntax, applied after Headers. It's reread
+	// when it changes.
+	HeadersFile string
+
+	// CORS is the policy for cross-origin requests. If it's nil, no CORS
+	// headers are sent, and browsers only allow same-origin requests.
+	CORS *CORS
+
+	// Proxies forward requests under some paths to other servers.
+	Proxies []Proxy
+
+	// LiveReload adds a script to HTML pages that reloads them when files
+	// in the tree change. It needs the tree to be on local disk.
+	LiveReload bool
+
+	// LiveReloadCSS makes live reload swap in changed stylesheets without
+	// reloading the page.
+	LiveReloadCSS bool
+
+	// Watch lets clients follow changes under a directory as server-sent
+	// events by requesting it with ?watch=1, and makes listings update
+	// live. It needs the tree to be on local disk.
+	Watch bool
+
+	// Follow lets clients follow a growing file, like tail -f, by
+	// requesting it with ?follow=1.
+	Follow bool
+
+	// FIFOs serves named pipes in the tree as downloads of whatever is
+	// written to them, which is read by the client that gets there first.
+	FIFOs bool
+}
+
+type handler struct {
+	fsys        fs.FS
+	opts        Options
+	archives    archiveCache
+	rules       []Rule
+	rulesFile   configFile
+	headers     []HeaderRule
+	headersFile configFile
+	proxies     []proxyRoute
+
+	watchOnce sync.Once
+	watcher   *watcher
+	watchErr  error
+
+	fifoMu      sync.Mutex
+	fifoReaders map[string]bool // local paths of pipes being read
+}
+
+// New returns a handler serving fsys. Directories are served by their
+// index.html if they have one and listed otherwise.
+func New(fsys fs.FS, opts Options) http.Handler {
+	h := &handler{fsys: fsys, opts: opts}
+	h.rules = append([]Rule(nil), opts.Rules...)
+	for i := range h.rules {
+		if err := h.rules[i].check(); err != nil {
+			panic(fmt.Sprintf("srv: invalid rule %s %s: %s", h.rules[i].From, h.rules[i].To, err))
+		}
+	}
+	h.headers = append([]HeaderRule(nil), opts.Headers...)
+	for i := range h.headers {
+		if err := h.headers[i].check(); err != nil {
+			panic(fmt.Sprintf("srv: invalid header rule %s: %s", h.headers[i].Path, err))
+		}
+	}
+	h.proxies = h.newProxies()
+	return h
+}
+
+// fsName maps a URL path to an fs.FS name.
+func fsName(urlPath string) string {
+	name := path.Clean("/" + urlPath)[1:]
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// configFile reports whether name is the rules or the headers file, or,
+// if orDir is set, a directory holding one. They're hidden, and can't be
+// changed through the handler.
+func (h *handler) configFile(name string, orDir bool) bool {
+	for _, f := range []string{h.opts.RulesFile, h.opts.HeadersFile} {
+		if f == "" {
+			continue
+		}
+		f = fsName(f)
+		if f == name || orDir && (name == "." || strings.HasPrefix(f, name+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Log != nil {
+		h.opts.Log.Printf("\t%s [%s]: %s %s %s", r.RemoteAddr, r.UserAgent(), r.Method, r.Proto, r.Host+r.RequestURI)
+	}
+
+	if h.proxy(w, r) {
+		return
+	}
+
+	if err := h.setHeaders(w, r); err != nil {
+		h.error(w, r, fmt.Sprintf("failed to read headers: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if h.cors(w, r) {
+		return
+	}
+
+	if h.opts.LiveReload {
+		if r.URL.Path == liveReloadPath {
+			h.serveLiveReload(w, r)
+			return
+		}
+		r = withRoot(r)
+	}
+
+	if r = h.applyRules(w, r); r == nil {
+		return
+	}
+
+	if h.opts.GitHTTP && h.serveGit(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r)
+	case http.MethodPut, http.MethodDelete, "MKCOL", "MOVE":
+		if !h.opts.Write {
+			h.error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			h.put(w, r)
+		case http.MethodDelete:
+			h.delete(w, r)
+		case "MKCOL":
+			h.mkcol(w, r)
+		case "MOVE":
+			h.move(w, r)
+		}
+	default:
+		h.error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// hideConfigFiles drops the rules and headers files from the entries of
+// the directory dir.
+func (h *handler) hideConfigFiles(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	shown := entries[:0]
+	for _, e := range entries {
+		if !h.configFile(path.Join(dir, e.Name()), false) {
+			shown = append(shown, e)
+		}
+	}
+	return shown
+}
+
+// get serves a file, or a directory by its index.html or a listing.
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	fsys, name := h.fsys, fsName(r.URL.Path)
+	rootName := name
+	if h.configFile(name, false) {
+		h.error(w, r, "file not found", http.StatusNotFound)
+		return
+	}
+	cleaned := false // whether name was found by adding .html
+	if h.opts.BrowseArchives {
+		var err error
+		fsys, name, err = h.archives.resolve(fsys, name, strings.HasSuffix(r.URL.Path, "/"))
+		if err != nil {
+			h.error(w, r, fmt.Sprintf("failed to open archive: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	fi, err := lstat(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) && h.opts.CleanURLs && !strings.HasSuffix(r.URL.Path, "/") {
+		if hfi, herr := lstat(fsys, name+".html"); herr == nil && hfi.Mode().IsRegular() {
+			name, fi, err = name+".html", hfi, nil
+			cleaned = true
+		}
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if h.serveSPA(w, r) {
+				return
+			}
+			h.error(w, r, "file not found", http.StatusNotFound)
+			return
+		}
+		h.error(w, r, fmt.Sprintf("failed to stat file: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch m := fi.Mode(); {
+	case m&fs.ModeDir != 0:
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			localRedirect(w, r, path.Base(requestPath(r))+"/")
+			return
+		}
+		local := name == rootName // not inside an archive
+		if h.opts.Watch && local && r.URL.Query().Get("watch") != "" {
+			h.serveWatch(w, r, name)
+			return
+		}
+		if html, err := fsys.Open(path.Join(name, "index.html")); err == nil {
+			if h.opts.LiveReload {
+				h.serveLiveHTML(w, r, html)
+			} else {
+				io.Copy(w, html)
+			}
+			html.Close()
+			return
+		}
+		files, err := fs.ReadDir(fsys, name)
+		if local {
+			files = h.hideConfigFiles(name, files)
+		}
+		if err == nil {
+			lo := listingOptions{browseArchives: h.opts.BrowseArchives, fifos: h.opts.FIFOs}
+			if h.opts.Watch && local {
+				_, err := h.watch()
+				lo.watch = err == nil
+			}
+			if _, ok := fsys.(WriteFS); ok && h.opts.Write && local {
+				_, canRename := fsys.(RenameFS)
+				lo.write, lo.rename = true, canRename
+				if o, ok := fsys.(Overlay); ok {
+					lo.writable = func(fn string) bool { return o.onlyInTop(path.Join(name, fn)) }
+				}
+			}
+			if a, ok := fsys.(Annotator); ok {
+				lo.annotate = func(fn string) string { return a.Annotate(path.Join(name, fn)) }
+			}
+			err = renderListing(w, r, files, lo)
+		}
+		if err != nil {
+			h.error(w, r, "failed to render directory listing: "+err.Error(), http.StatusInternalServerError)
+		}
+	case m&fs.ModeType == 0:
+		if h.opts.Follow && r.URL.Query().Get("follow") != "" {
+			h.serveFollow(w, r, fsys, name)
+			return
+		}
+		// Only for what the client asked for, not what a rule rewrote it to.
+		if h.opts.StripHTML && !cleaned && strings.HasSuffix(name, ".html") && strings.HasSuffix(requestPath(r), ".html") {
+			base := strings.TrimSuffix(path.Base(name), ".html")
+			if base == "index" {
+				base = ""
+			}
+			localRedirect(w, r, "./"+url.PathEscape(base))
+			return
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			h.error(w, r, fmt.Sprintf("failed to open file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		if h.opts.LiveReload && isHTML(name) {
+			h.serveLiveHTML(w, r, f)
+			return
+		}
+		serveFile(w, r, name, fi, f)
+	case m&fs.ModeNamedPipe != 0 && h.opts.FIFOs:
+		h.serveFIFO(w, r, fsys, name)
+	case m&fs.ModeSymlink != 0:
+		h.error(w, r, "file is a symlink", http.StatusForbidden)
+	default:
+		h.error(w, r, "file isn't a regular file or directory", http.StatusForbidden)
+	}
+}
+
+// requestPath returns the escaped path the client asked for, before any
+// prefix was stripped from it.
+func requestPath(r *http.Request) string {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return u.EscapedPath()
+	}
+	return r.URL.EscapedPath()
+}
+
+// localRedirect redirects to a URL relative to the request's, which keeps
+// working when the handler is mounted under a prefix.
+func localRedirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// error replies with the error page configured for code, or msg as plain
+// text if there's none.
+func (h *handler) error(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if page, ok := h.opts.ErrorPages[code]; ok && h.servePage(w, page, code) {
+		return
+	}
+	http.Error(w, msg, code)
+}
+
+// servePage serves the file page, relative to the root, with status code.
+func (h *handler) servePage(w http.ResponseWriter, page string, code int) bool {
+	f, err := h.fsys.Open(fsName(page))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	ctype := mime.TypeByExtension(path.Ext(page))
+	if ctype == "" {
+		ctype = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.WriteHeader(code)
+	io.Copy(w, f)
+	return true
+}
+
+// serveSPA serves the single-page app whose prefix is the longest to match
+// the request, if any.
+func (h *handler) serveSPA(w http.ResponseWriter, r *http.Request) bool {
+	var page, longest string
+	for prefix, p := range h.opts.SPA {
+		dir := strings.TrimSuffix(prefix, "/")
+		if (r.URL.Path == dir || strings.HasPrefix(r.URL.Path, dir+"/")) && len(prefix) >= len(longest) {
+			page, longest = p, prefix
+		}
+	}
+	if page == "" {
+		return false
+	}
+	name := fsName(page)
+	fi, err := fs.Stat(h.fsys, name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if h.opts.LiveReload && isHTML(name) {
+		h.serveLiveHTML(w, r, f)
+		return true
+	}
+	serveFile(w, r, name, fi, f)
+	return true
+}
+
+// serveFile serves f with range support if it can seek, and as a plain
+// stream otherwise.
+func serveFile(w http.ResponseWriter, r *http.Request, name string, fi fs.FileInfo, f fs.File) {
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, time.Time{}, rs)
+		return
+	}
+
+	var body io.Reader = f
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		br := bufio.NewReaderSize(f, 512)
+		sniff, _ := br.Peek(512)
+		ctype = http.DetectContentType(sniff)
+		body = br
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
@@ -0,0 +1,180 @@
+package srv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a change to a file in the served tree.
+type Event struct {
+	// Op is create, modify, delete or rename, or overflow if events were
+	// lost.
+	Op string `json:"op"`
+
+	// Name is the file's path relative to the root, and To where it was
+	// renamed to.
+	Name string `json:"name"`
+	To   string `json:"to,omitempty"`
+
+	// Dir is set if the file is a directory.
+	Dir bool `json:"dir,omitempty"`
+}
+
+// watcher fans out the events of a recursive watch of the served tree to
+// its subscribers.
+type watcher struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func (w *watcher) publish(ev Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subs {
+		select {
+		case ch <- ev:
+		default:
+			// The subscriber is falling behind; drop the event.
+		}
+	}
+}
+
+// subscribe returns a channel of events, and a function to call when done
+// with it.
+func (w *watcher) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 256)
+	w.mu.Lock()
+	w.subs[ch] = struct{}{}
+	w.mu.Unlock()
+	return ch, func() {
+		w.mu.Lock()
+		delete(w.subs, ch)
+		w.mu.Unlock()
+	}
+}
+
+// localRoots returns the local directories making up fsys.
+func localRoots(fsys fs.FS) []string {
+	var roots []string
+	if o, ok := fsys.(Overlay); ok {
+		for _, layer := range o {
+			roots = append(roots, localRoots(layer)...)
+		}
+		return roots
+	}
+	if lfs, ok := fsys.(LocalFS); ok {
+		if dir, err := lfs.LocalPath("."); err == nil {
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
+
+// watch returns the handler's watcher, starting it the first time.
+func (h *handler) watch() (*watcher, error) {
+	h.watchOnce.Do(func() {
+		roots := localRoots(h.fsys)
+		if len(roots) == 0 {
+			h.watchErr = errors.New("only local directories can be watched")
+			return
+		}
+		w := &watcher{subs: make(map[chan Event]struct{})}
+		for _, root := range roots {
+			if err := watchTree(root, w.publish); err != nil {
+				h.watchErr = err
+				return
+			}
+		}
+		h.watcher = w
+	})
+	return h.watcher, h.watchErr
+}
+
+// relEvent makes ev relative to the directory dir, reporting whether it
+// concerns anything under it.
+func relEvent(ev Event, dir string) (Event, bool) {
+	if ev.Op == "overflow" || dir == "." {
+		return ev, true
+	}
+	rel := func(name string) (string, bool) {
+		if name == dir {
+			return ".", true
+		}
+		if strings.HasPrefix(name, dir+"/") {
+			return name[len(dir)+1:], true
+		}
+		return "", false
+	}
+	from, fromOK := rel(ev.Name)
+	if ev.Op != "rename" {
+		ev.Name = from
+		return ev, fromOK
+	}
+	to, toOK := rel(ev.To)
+	switch {
+	case fromOK && toOK:
+		ev.Name, ev.To = from, to
+	case fromOK:
+		ev = Event{Op: "delete", Name: from, Dir: ev.Dir}
+	case toOK:
+		ev = Event{Op: "create", Name: to, Dir: ev.Dir}
+	}
+	return ev, fromOK || toOK
+}
+
+// serveWatch streams server-sent events for changes under the directory
+// dir, as JSON-encoded Events with names relative to it.
+func (h *handler) serveWatch(w http.ResponseWriter, r *http.Request, dir string) {
+	wt, err := h.watch()
+	if err != nil {
+		h.error(w, r, fmt.Sprintf("failed to watch files: %s", err), http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.error(w, r, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := wt.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, ": watching\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		case ev := <-events:
+			ev, ok := relEvent(ev, dir)
+			if !ok {
+				continue
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			if ev.Name == "." && ev.Op != "overflow" && ev.Op != "modify" {
+				// The directory itself is gone.
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,178 @@
+//go:build linux
+// +build linux
+
+package srv
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// inotify watches a directory tree, with a watch per directory.
+type inotify struct {
+	fd   int
+	root string
+
+	mu   sync.Mutex
+	dirs map[int32]string // watch descriptor to relative path
+}
+
+// watchTree watches the directory tree at root with inotify, passing
+// changes to publish.
+func watchTree(root string, publish func(Event)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	in := &inotify{fd: fd, root: root, dirs: make(map[int32]string)}
+	if err := in.add("."); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	in.addTree(".")
+	go in.run(publish)
+	return nil
+}
+
+// add watches the directory name.
+func (in *inotify) add(name string) error {
+	wd, err := syscall.InotifyAddWatch(in.fd, filepath.Join(in.root, filepath.FromSlash(name)), inotifyMask)
+	if err != nil {
+		return &fs.PathError{Op: "inotify_add_watch", Path: name, Err: err}
+	}
+	in.mu.Lock()
+	in.dirs[int32(wd)] = name
+	in.mu.Unlock()
+	return nil
+}
+
+// addTree watches the directories under name. Failures, as from running
+// out of watches, leave parts of the tree unwatched.
+func (in *inotify) addTree(name string) {
+	filepath.WalkDir(filepath.Join(in.root, filepath.FromSlash(name)), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(in.root, p); err == nil && rel != filepath.FromSlash(name) {
+			in.add(filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
+
+// renamed updates the paths of watched directories under a renamed one.
+func (in *inotify) renamed(from, to string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for wd, name := range in.dirs {
+		if name == from {
+			in.dirs[wd] = to
+		} else if strings.HasPrefix(name, from+"/") {
+			in.dirs[wd] = to + name[len(from):]
+		}
+	}
+}
+
+// removed stops watching a directory moved out of the tree, and the
+// directories under it, whose watches would otherwise go on reporting
+// changes under its old path.
+func (in *inotify) removed(name string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for wd, dir := range in.dirs {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			syscall.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.dirs, wd)
+		}
+	}
+}
+
+func (in *inotify) run(publish func(Event)) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(in.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			return
+		}
+
+		var moved *Event // a rename waiting for its destination
+		var movedCookie uint32
+		flush := func() {
+			if moved != nil {
+				if moved.Dir {
+					in.removed(moved.Name)
+				}
+				publish(Event{Op: "delete", Name: moved.Name, Dir: moved.Dir})
+				moved = nil
+			}
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(raw.Len)]
+			off += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				flush()
+				publish(Event{Op: "overflow"})
+				continue
+			}
+			in.mu.Lock()
+			dir, ok := in.dirs[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(in.dirs, raw.Wd)
+			}
+			in.mu.Unlock()
+			if !ok || raw.Len == 0 {
+				continue
+			}
+			name := path.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+			isDir := raw.Mask&syscall.IN_ISDIR != 0
+
+			if moved != nil && (raw.Mask&syscall.IN_MOVED_TO == 0 || raw.Cookie != movedCookie) {
+				flush()
+			}
+			switch {
+			case raw.Mask&syscall.IN_CREATE != 0:
+				if isDir {
+					in.add(name)
+					in.addTree(name)
+				}
+				publish(Event{Op: "create", Name: name, Dir: isDir})
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				publish(Event{Op: "modify", Name: name})
+			case raw.Mask&syscall.IN_DELETE != 0:
+				publish(Event{Op: "delete", Name: name, Dir: isDir})
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				moved, movedCookie = &Event{Name: name, Dir: isDir}, raw.Cookie
+			case raw.Mask&syscall.IN_MOVED_TO != 0:
+				if moved != nil {
+					if isDir {
+						in.renamed(moved.Name, name)
+					}
+					publish(Event{Op: "rename", Name: moved.Name, To: name, Dir: isDir})
+					moved = nil
+					continue
+				}
+				if isDir {
+					in.add(name)
+					in.addTree(name)
+				}
+				publish(Event{Op: "create", Name: name, Dir: isDir})
+			}
+		}
+		flush()
+	}
+}
@@ -0,0 +1,48 @@
+package srv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchDirMovedOut(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan Event, 16)
+	if err := watchTree(root, func(ev Event) { events <- ev }); err != nil {
+		t.Fatal(err)
+	}
+	next := func() Event {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+
+	if err := os.Rename(filepath.Join(root, "a"), filepath.Join(outside, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev != (Event{Op: "delete", Name: "a", Dir: true}) {
+		t.Fatalf("got %+v, want the deletion of a", ev)
+	}
+
+	// Changes in the moved directory are no longer the tree's.
+	for _, name := range []string{"a/x", "a/b/x"} {
+		if err := os.WriteFile(filepath.Join(outside, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "marker"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev != (Event{Op: "create", Name: "marker"}) {
+		t.Errorf("got %+v, want the creation of marker", ev)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package srv
+
+import "errors"
+
+// watchTree would watch the directory tree at root for changes.
+func watchTree(root string, publish func(Event)) error {
+	return errors.New("watching files isn't supported on this platform")
+}
@@ -0,0 +1,253 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// configFileMsg is the error message refusing to change the rules or headers
+// file.
+const configFileMsg = "can't change the rules or headers file"
+
+// put stores the request body at the request path.
+func (h *handler) put(w http.ResponseWriter, r *http.Request) {
+	wfs, ok := h.fsys.(WriteFS)
+	if !ok {
+		h.error(w, r, "filesystem is read-only", http.StatusForbidden)
+		return
+	}
+	name := fsName(r.URL.Path)
+	if name == "." || strings.HasSuffix(r.URL.Path, "/") {
+		h.error(w, r, "can't upload to a directory", http.StatusConflict)
+		return
+	}
+	if h.configFile(name, false) {
+		h.error(w, r, configFileMsg, http.StatusForbidden)
+		return
+	}
+
+	status := http.StatusCreated
+	fi, err := lstat(h.fsys, name)
+	switch {
+	case err == nil && !fi.Mode().IsRegular():
+		h.error(w, r, "destination isn't a regular file", http.StatusConflict)
+		return
+	case err == nil:
+		status = http.StatusNoContent
+	case !errors.Is(err, fs.ErrNotExist):
+		h.error(w, r, fmt.Sprintf("failed to stat file: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	u, err := wfs.Create(name)
+	if err != nil {
+		h.fsError(w, r, "failed to create file", err)
+		return
+	}
+	if _, err := io.Copy(u, r.Body); err != nil {
+		u.Abort()
+		h.error(w, r, fmt.Sprintf("failed to write file: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if err := u.Close(); err != nil {
+		h.error(w, r, fmt.Sprintf("failed to write file: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// fsError reports a failed filesystem operation with a status matching its
+// cause.
+func (h *handler) fsError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusConflict
+	case errors.Is(err, fs.ErrPermission):
+		status = http.StatusForbidden
+	}
+	h.error(w, r, fmt.Sprintf("%s: %s", msg, err), status)
+}
+
+// removeAll removes name and, if it's a directory, everything in it.
+func removeAll(wfs WriteFS, name string) error {
+	if rfs, ok := wfs.(RemoveAllFS); ok {
+		return rfs.RemoveAll(name)
+	}
+	fi, err := lstat(wfs, name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := fs.ReadDir(wfs, name)
+		if err != nil {
+			return err
+		}
+		for _, de := range entries {
+			if err := removeAll(wfs, path.Join(name, de.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	// Directories of S3 and the like vanish with their last file.
+	if err := wfs.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// delete removes the file or directory at the request path. Directories
+// with anything in them are only removed with ?recursive=1.
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) {
+	wfs, ok := h.fsys.(WriteFS)
+	if !ok {
+		h.error(w, r, "filesystem is read-only", http.StatusForbidden)
+		return
+	}
+	name := fsName(r.URL.Path)
+	if name == "." {
+		h.error(w, r, "can't delete the root directory", http.StatusForbidden)
+		return
+	}
+	if h.configFile(name, true) {
+		h.error(w, r, configFileMsg, http.StatusForbidden)
+		return
+	}
+	fi, err := lstat(h.fsys, name)
+	if err != nil {
+		h.error(w, r, "file not found", http.StatusNotFound)
+		return
+	}
+
+	if fi.IsDir() && r.URL.Query().Get("recursive") == "1" {
+		err = removeAll(wfs, name)
+	} else {
+		if fi.IsDir() {
+			if entries, err := fs.ReadDir(h.fsys, name); err == nil && len(entries) > 0 {
+				h.error(w, r, "directory isn't empty; delete it with ?recursive=1", http.StatusConflict)
+				return
+			}
+		}
+		err = wfs.Remove(name)
+	}
+	if err != nil {
+		h.fsError(w, r, "failed to delete", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mkcol creates a directory at the request path, whose parent must exist.
+func (h *handler) mkcol(w http.ResponseWriter, r *http.Request) {
+	wfs, ok := h.fsys.(WriteFS)
+	if !ok {
+		h.error(w, r, "filesystem is read-only", http.StatusForbidden)
+		return
+	}
+	name := fsName(r.URL.Path)
+	if h.configFile(name, false) {
+		h.error(w, r, configFileMsg, http.StatusForbidden)
+		return
+	}
+	if _, err := lstat(h.fsys, name); err == nil {
+		h.error(w, r, "already exists", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := wfs.Mkdir(name); err != nil {
+		h.fsError(w, r, "failed to create directory", err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// move renames the file or directory at the request path to the one in
+// the Destination header, replacing a file there unless Overwrite is F.
+func (h *handler) move(w http.ResponseWriter, r *http.Request) {
+	wfs, ok := h.fsys.(WriteFS)
+	if !ok {
+		h.error(w, r, "filesystem is read-only", http.StatusForbidden)
+		return
+	}
+	rfs, ok := wfs.(RenameFS)
+	if !ok {
+		h.error(w, r, "filesystem can't rename files", http.StatusNotImplemented)
+		return
+	}
+	name := fsName(r.URL.Path)
+	dest, err := destination(r)
+	if err != nil {
+		h.error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name == "." || dest == "." {
+		h.error(w, r, "can't move the root directory", http.StatusForbidden)
+		return
+	}
+	if dest == name {
+		h.error(w, r, "source and destination are the same", http.StatusForbidden)
+		return
+	}
+	if h.configFile(name, true) || h.configFile(dest, true) {
+		h.error(w, r, configFileMsg, http.StatusForbidden)
+		return
+	}
+	fi, err := lstat(h.fsys, name)
+	if err != nil {
+		h.error(w, r, "file not found", http.StatusNotFound)
+		return
+	}
+	if fi.IsDir() && strings.HasPrefix(dest, name+"/") {
+		h.error(w, r, "can't move a directory into itself", http.StatusConflict)
+		return
+	}
+
+	status := http.StatusCreated
+	if dfi, err := lstat(h.fsys, dest); err == nil {
+		switch {
+		case r.Header.Get("Overwrite") == "F":
+			h.error(w, r, "destination exists", http.StatusPreconditionFailed)
+			return
+		case dfi.IsDir():
+			h.error(w, r, "destination is a directory", http.StatusConflict)
+			return
+		case fi.IsDir():
+			h.error(w, r, "destination is a file", http.StatusConflict)
+			return
+		}
+		status = http.StatusNoContent
+	}
+	if err := rfs.Rename(name, dest); err != nil {
+		h.fsError(w, r, "failed to move", err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// destination returns the name the Destination header of r points to. It
+// may be a URL, or a path relative to the request's, and is taken to be
+// under the same prefix as the request, when mounted with http.StripPrefix.
+func destination(r *http.Request) (string, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return "", errors.New("missing or invalid destination")
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", errors.New("destination is on another server")
+	}
+	orig, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil || !strings.HasSuffix(orig.Path, r.URL.Path) {
+		return fsName(u.Path), nil
+	}
+	p := orig.ResolveReference(u).Path
+	prefix := strings.TrimSuffix(orig.Path, r.URL.Path)
+	if !strings.HasPrefix(p, prefix+"/") {
+		return "", errors.New("destination is outside the served tree")
+	}
+	return fsName(p[len(prefix):]), nil
+}
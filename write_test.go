@@ -0,0 +1,158 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		target, strip, dest string
+		want                string
+		ok                  bool
+	}{
+		{"/a/b.txt", "", "/a/c.txt", "a/c.txt", true},
+		{"/a/b.txt", "", "c.txt", "a/c.txt", true},
+		{"/a/b.txt", "", "../c%20d.txt", "c d.txt", true},
+		{"/a/b.txt", "", "http://example.com/x/y", "x/y", true},
+		{"/a/b.txt", "", "http://elsewhere.test/x", "", false},
+		{"/a/b.txt", "", "", "", false},
+		{"/a/b.txt", "", "%zz", "", false},
+		// Mounted at /files/ with http.StripPrefix.
+		{"/files/a/b.txt", "/files", "/files/a/c.txt", "a/c.txt", true},
+		{"/files/a/b.txt", "/files", "c.txt", "a/c.txt", true},
+		{"/files/a/b.txt", "/files", "/other/c.txt", "", false},
+		{"/files/a/b.txt", "/files", "../../c.txt", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("MOVE", "http://example.com"+tt.target, nil)
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, tt.strip)
+		r.Header.Set("Destination", tt.dest)
+		got, err := destination(r)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("%s to %q = %q, %v; want %q, ok %v", tt.target, tt.dest, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteMethods(t *testing.T) {
+	dir := t.TempDir()
+	h := New(DirFS(dir), Options{Write: true})
+	do := func(method, target string, header ...string) int {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader("data"))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	steps := []struct {
+		method, target string
+		header         []string
+		status         int
+	}{
+		{"MKCOL", "/d", nil, 201},
+		{"MKCOL", "/d", nil, 405},
+		{"MKCOL", "/x/y", nil, 409},
+		{"PUT", "/d/f", nil, 201},
+		{"PUT", "/d/f", nil, 204},
+		{"PUT", "/g", nil, 201},
+		{"MOVE", "/g", []string{"Destination", "/d/f", "Overwrite", "F"}, 412},
+		{"MOVE", "/g", []string{"Destination", "/d"}, 409},
+		{"MOVE", "/d", []string{"Destination", "/d/e"}, 409},
+		{"MOVE", "/g", []string{"Destination", "/d/f"}, 204},
+		{"MOVE", "/d/f", []string{"Destination", "../h"}, 201},
+		{"MOVE", "/missing", []string{"Destination", "/m"}, 404},
+		{"MOVE", "/h", []string{"Destination", "/"}, 403},
+		{"PUT", "/d/f", nil, 201},
+		{"DELETE", "/d", nil, 409},
+		{"DELETE", "/d?recursive=1", nil, 204},
+		{"DELETE", "/h", nil, 204},
+		{"DELETE", "/h", nil, 404},
+		{"DELETE", "/", nil, 403},
+	}
+	for _, s := range steps {
+		if got := do(s.method, s.target, s.header...); got != s.status {
+			t.Errorf("%s %s %v: status %d, want %d", s.method, s.target, s.header, got, s.status)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("left %v, %v", entries, err)
+	}
+
+	h = New(DirFS(dir), Options{})
+	os.WriteFile(filepath.Join(dir, "f"), nil, 0o666)
+	for _, method := range []string{"PUT", "DELETE", "MKCOL", "MOVE"} {
+		if got := do(method, "/f", "Destination", "/g"); got != http.StatusMethodNotAllowed {
+			t.Errorf("%s without Write: status %d", method, got)
+		}
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "_redirects"), []byte("/old /new 302\n"), 0o666)
+	os.Mkdir(filepath.Join(dir, "conf"), 0o777)
+	os.WriteFile(filepath.Join(dir, "conf", "_headers"), nil, 0o666)
+	os.WriteFile(filepath.Join(dir, "g"), nil, 0o666)
+	h := New(DirFS(dir), Options{Write: true, RulesFile: "_redirects", HeadersFile: "/conf/_headers"})
+	do := func(method, target string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader("data"))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, target := range []string{"/_redirects", "/conf/_headers", "/conf/../_redirects"} {
+		if w := do("GET", target); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", target, w.Code)
+		}
+	}
+	for target, name := range map[string]string{"/": "_redirects", "/conf/": "_headers"} {
+		if w := do("GET", target); w.Code != http.StatusOK || strings.Contains(w.Body.String(), name) {
+			t.Errorf("GET %s: status %d, listing %s", target, w.Code, name)
+		}
+	}
+	if w := do("GET", "/"); !strings.Contains(w.Body.String(), "conf") {
+		t.Error("the listing hides conf")
+	}
+
+	steps := []struct {
+		method, target string
+		header         []string
+	}{
+		{"PUT", "/_redirects", nil},
+		{"PUT", "/conf/_headers", nil},
+		{"DELETE", "/_redirects", nil},
+		{"DELETE", "/conf?recursive=1", nil},
+		{"MKCOL", "/_redirects", nil},
+		{"MOVE", "/_redirects", []string{"Destination", "/r"}},
+		{"MOVE", "/conf", []string{"Destination", "/c"}},
+		{"MOVE", "/g", []string{"Destination", "/_redirects"}},
+		{"MOVE", "/g", []string{"Destination", "/conf/_headers"}},
+	}
+	for _, s := range steps {
+		if w := do(s.method, s.target, s.header...); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s %v: status %d, want 403", s.method, s.target, s.header, w.Code)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "_redirects")); err != nil || string(data) != "/old /new 302\n" {
+		t.Errorf("_redirects holds %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "conf", "_headers")); err != nil {
+		t.Errorf("conf/_headers: %v", err)
+	}
+	if w := do("GET", "/old"); w.Code != http.StatusFound {
+		t.Errorf("GET /old: status %d, want the rule's 302", w.Code)
+	}
+}